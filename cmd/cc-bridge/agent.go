@@ -0,0 +1,507 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/binaryphile/cc-bridge/internal/broker"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+	"github.com/binaryphile/cc-bridge/internal/workspace"
+)
+
+// defaultAgents are registered the first time the registry is opened
+var defaultAgents = []string{schema.AgentA, schema.AgentB}
+
+// openRegistry opens the agent registry, seeding it with the default agents
+// if it has never been written. Like added agents, they get workspaces.
+func openRegistry(cmd *Command) (*registry.Registry, error) {
+	reg, err := registry.NewRegistry(cmd.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := reg.Seed(registry.Profile{Workspace: &registry.Workspace{}}, defaultAgents...); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+func runAgentCmd(cmd *Command) {
+	reg, err := openRegistry(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open agent registry: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch cmd.Subcommand {
+	case "add":
+		runAgentAdd(cmd, reg)
+	case "set":
+		runAgentSet(cmd, reg)
+	case "remove":
+		runAgentRemove(cmd, reg)
+	case "list":
+		runAgentList(reg)
+	}
+}
+
+func runAgentAdd(cmd *Command, reg *registry.Registry) {
+	if len(cmd.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: agent add requires at least one agent ID\n")
+		os.Exit(1)
+	}
+
+	profile, err := applyProfileFlags(cmd, registry.Profile{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	profile = defaultWorkspace(cmd, profile)
+
+	for _, id := range cmd.Args {
+		if err := ensureWorkspace(cmd, id, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := reg.Add(id, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %s\n", id)
+	}
+}
+
+// runAgentSet changes only the profile settings given on the command line
+func runAgentSet(cmd *Command, reg *registry.Registry) {
+	if len(cmd.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: agent set requires at least one agent ID\n")
+		os.Exit(1)
+	}
+
+	for _, id := range cmd.Args {
+		a, err := reg.Get(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		profile, err := applyProfileFlags(cmd, a.Profile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := checkMove(cmd, id, a.Profile, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ensureWorkspace(cmd, id, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := reg.SetProfile(id, profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated %s\n", id)
+	}
+}
+
+// applyProfileFlags overlays the profile flags given on the command line
+// onto p. An empty value clears a setting.
+func applyProfileFlags(cmd *Command, p registry.Profile) (registry.Profile, error) {
+	if cmd.SetFlags["model"] {
+		p.Model = cmd.Model
+	}
+	if cmd.SetFlags["system-prompt"] {
+		p.SystemPrompt = cmd.SystemPrompt
+	}
+	if cmd.SetFlags["append-system-prompt"] {
+		p.AppendSystemPrompt = cmd.AppendSystemPrompt
+	}
+	if cmd.SetFlags["allowed-tools"] {
+		p.AllowedTools = splitList(cmd.AllowedTools)
+	}
+	if cmd.SetFlags["disallowed-tools"] {
+		p.DisallowedTools = splitList(cmd.DisallowedTools)
+	}
+	if cmd.SetFlags["permission-mode"] {
+		p.PermissionMode = cmd.PermissionMode
+	}
+	if cmd.SetFlags["max-turns"] {
+		if cmd.MaxTurns < 0 {
+			return p, fmt.Errorf("--max-turns must not be negative")
+		}
+		p.MaxTurns = cmd.MaxTurns
+	}
+	if cmd.SetFlags["work-dir"] {
+		p.WorkDir = ""
+		if cmd.WorkDir != "" {
+			// The broker may run from another directory
+			dir, err := filepath.Abs(cmd.WorkDir)
+			if err != nil {
+				return p, fmt.Errorf("invalid --work-dir: %w", err)
+			}
+			p.WorkDir = dir
+		}
+	}
+	if cmd.SetFlags["workspace"] || cmd.SetFlags["workspace-repo"] {
+		p.Workspace = nil
+		if cmd.Workspace || cmd.WorkspaceRepo != "" {
+			ws := &registry.Workspace{}
+			if cmd.WorkspaceRepo != "" {
+				repo, err := filepath.Abs(cmd.WorkspaceRepo)
+				if err != nil {
+					return p, fmt.Errorf("invalid --workspace-repo: %w", err)
+				}
+				ws.Repo = repo
+			}
+			p.Workspace = ws
+		}
+	}
+	if cmd.SetFlags["turn-timeout"] {
+		if cmd.TurnTimeout < 0 {
+			return p, fmt.Errorf("--turn-timeout must not be negative")
+		}
+		p.TurnTimeout = cmd.TurnTimeout
+	}
+	if cmd.SetFlags["budget"] {
+		if cmd.Budget < 0 {
+			return p, fmt.Errorf("--budget must not be negative")
+		}
+		p.Budget = cmd.Budget
+	}
+	if cmd.SetFlags["endpoint"] {
+		p.Endpoint = strings.TrimSpace(cmd.Endpoint)
+		if p.Endpoint != "" {
+			u, err := url.Parse(p.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return p, fmt.Errorf("invalid --endpoint %q: want an http(s) URL", cmd.Endpoint)
+			}
+		}
+	}
+
+	p, err := applyCommandFlags(cmd, p)
+	if err != nil {
+		return p, err
+	}
+	if p.Command != nil && p.Endpoint != "" {
+		return p, fmt.Errorf("an agent cannot have both a command and an endpoint")
+	}
+	if p.Endpoint != "" && p.Model == "" {
+		return p, fmt.Errorf("an agent with an endpoint needs --model")
+	}
+	if p.Workspace != nil && p.WorkDir != "" {
+		return p, fmt.Errorf("an agent cannot have both a work dir and a workspace")
+	}
+	return p, nil
+}
+
+// executorFactory builds each agent's executor from its profile. An agent
+// with a workspace runs there instead of its work dir.
+func executorFactory(cmd *Command, sMgr *session.Manager, wsMgr *workspace.Manager) broker.ExecutorFactory {
+	return func(a *registry.Agent) broker.Executor {
+		p := a.Profile
+		if p.Workspace != nil {
+			p.WorkDir = wsMgr.Path(a.ID)
+		}
+		if p.Command != nil {
+			return &broker.CommandExecutor{Command: *p.Command, WorkDir: p.WorkDir}
+		}
+		if p.Endpoint != "" {
+			return httpExecutor(p, sMgr)
+		}
+		return &broker.ClaudeExecutor{Stream: cmd.Stream, Profile: p}
+	}
+}
+
+// httpExecutor builds the executor for an agent backed by a Messages API
+// endpoint. There is no default system prompt to append to, so both prompts
+// are sent together.
+func httpExecutor(p registry.Profile, sMgr *session.Manager) *broker.HTTPExecutor {
+	var system []string
+	for _, prompt := range []string{p.SystemPrompt, p.AppendSystemPrompt} {
+		if prompt != "" {
+			system = append(system, prompt)
+		}
+	}
+	return &broker.HTTPExecutor{
+		BaseURL:  p.Endpoint,
+		APIKey:   os.Getenv("ANTHROPIC_API_KEY"),
+		Model:    p.Model,
+		System:   strings.Join(system, "\n\n"),
+		Sessions: sMgr,
+	}
+}
+
+// applyCommandFlags overlays the command flags onto p. An empty --command
+// switches the agent back to claude.
+func applyCommandFlags(cmd *Command, p registry.Profile) (registry.Profile, error) {
+	if cmd.SetFlags["command"] {
+		if cmd.AgentCommand == "" {
+			p.Command = nil
+		} else {
+			argv, err := splitCommand(cmd.AgentCommand)
+			if err != nil {
+				return p, fmt.Errorf("invalid --command: %w", err)
+			}
+			// Replacing the command line keeps its stdin and output settings
+			c := registry.Command{}
+			if p.Command != nil {
+				c = *p.Command
+			}
+			c.Argv = argv
+			p.Command = &c
+		}
+	}
+
+	touched := cmd.SetFlags["command-stdin"] || cmd.SetFlags["command-output"] || cmd.SetFlags["command-map"]
+	if !touched {
+		return p, nil
+	}
+	if p.Command == nil {
+		return p, fmt.Errorf("--command-stdin, --command-output and --command-map require --command")
+	}
+
+	c := *p.Command
+	if cmd.SetFlags["command-stdin"] {
+		c.Stdin = cmd.CommandStdin
+	}
+	if cmd.SetFlags["command-output"] {
+		c.Output = cmd.CommandOutput
+	}
+	if cmd.SetFlags["command-map"] {
+		c.Fields = nil
+		for _, mapping := range splitList(cmd.CommandMap) {
+			field, path, ok := strings.Cut(mapping, "=")
+			if !ok {
+				return p, fmt.Errorf("invalid --command-map entry %q (want field=path)", mapping)
+			}
+			if c.Fields == nil {
+				c.Fields = make(map[string]string)
+			}
+			c.Fields[strings.TrimSpace(field)] = strings.TrimSpace(path)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return p, err
+	}
+	p.Command = &c
+	return p, nil
+}
+
+// splitCommand splits a command line into arguments. Single and double
+// quotes group words and a backslash escapes the next character.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && quote != '\'':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runAgentRemove(cmd *Command, reg *registry.Registry) {
+	if len(cmd.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: agent remove requires at least one agent ID\n")
+		os.Exit(1)
+	}
+
+	qMgr, err := queue.NewManager(filepath.Join(cmd.DataDir, "queues"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create queue manager: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, id := range cmd.Args {
+		if _, err := reg.Get(id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		q, err := qMgr.GetQueue(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+			os.Exit(1)
+		}
+
+		if cmd.Archive {
+			archive, err := qMgr.GetArchiveQueue(id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to get archive queue: %v\n", err)
+				os.Exit(1)
+			}
+			// Unregister first so nothing more is routed to the queue. Leased
+			// messages are left alone, as queue purge does: archiving one while
+			// its worker still holds the lease could archive a message that
+			// also gets answered. The worker puts it back when it is stopped.
+			removeAgent(reg, id)
+			n, err := q.MoveTo(archive)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to archive queue for %s: %v\n", id, err)
+				os.Exit(1)
+			}
+			line := fmt.Sprintf("Removed %s (%d message(s) archived", id, n)
+			if leases, err := q.InFlight(); err == nil && len(leases) > 0 {
+				line += fmt.Sprintf("; %d in flight left alone", len(leases))
+			}
+			fmt.Println(line + ")")
+			continue
+		}
+
+		n, err := q.Len()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read queue for %s: %v\n", id, err)
+			os.Exit(1)
+		}
+		leases, err := q.InFlight()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read queue for %s: %v\n", id, err)
+			os.Exit(1)
+		}
+		if n == 0 && len(leases) == 0 {
+			removeAgent(reg, id)
+			fmt.Printf("Removed %s\n", id)
+			continue
+		}
+
+		// The broker finishes the remaining messages, then unregisters the agent
+		if err := reg.SetState(id, registry.StateDraining); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Draining %s (%d queued, %d in flight); it is removed once its queue is empty\n",
+			id, n, len(leases))
+	}
+}
+
+func removeAgent(reg *registry.Registry, id string) {
+	if err := reg.Remove(id); err != nil && !errors.Is(err, registry.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runAgentList(reg *registry.Registry) {
+	agents, err := reg.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list agents: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(agents) == 0 {
+		fmt.Println("No agents registered")
+		return
+	}
+
+	for _, a := range agents {
+		fmt.Printf("%-20s %-9s added %s\n", a.ID, a.State, a.AddedAt.Local().Format("2006-01-02 15:04:05"))
+		if summary := profileSummary(a.Profile); summary != "" {
+			fmt.Printf("  %s\n", summary)
+		}
+	}
+}
+
+// profileSummary describes the settings a profile overrides
+func profileSummary(p registry.Profile) string {
+	var parts []string
+	if p.Endpoint != "" {
+		parts = append(parts, "endpoint "+p.Endpoint)
+	}
+	if c := p.Command; c != nil {
+		parts = append(parts, "command "+strings.Join(c.Argv, " "))
+		if c.Output == registry.OutputJSON {
+			parts = append(parts, "json output")
+		}
+	}
+	if p.Model != "" {
+		parts = append(parts, "model "+p.Model)
+	}
+	if p.MaxTurns > 0 {
+		parts = append(parts, fmt.Sprintf("max turns %d", p.MaxTurns))
+	}
+	if p.PermissionMode != "" {
+		parts = append(parts, "permissions "+p.PermissionMode)
+	}
+	if p.Budget > 0 {
+		parts = append(parts, fmt.Sprintf("budget $%.2f", p.Budget))
+	}
+	if p.TurnTimeout > 0 {
+		parts = append(parts, "turn timeout "+p.TurnTimeout.String())
+	}
+	if len(p.AllowedTools) > 0 {
+		parts = append(parts, "allowed "+strings.Join(p.AllowedTools, ","))
+	}
+	if len(p.DisallowedTools) > 0 {
+		parts = append(parts, "disallowed "+strings.Join(p.DisallowedTools, ","))
+	}
+	if p.SystemPrompt != "" {
+		parts = append(parts, "custom system prompt")
+	}
+	if p.AppendSystemPrompt != "" {
+		parts = append(parts, "appended system prompt")
+	}
+	if p.WorkDir != "" {
+		parts = append(parts, "in "+p.WorkDir)
+	}
+	if ws := p.Workspace; ws != nil {
+		if ws.Repo != "" {
+			parts = append(parts, "workspace of "+ws.Repo)
+		} else {
+			parts = append(parts, "workspace")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestParseArgs_AgentRemoveArchive(t *testing.T) {
+	cmd, err := ParseArgs([]string{"agent", "remove", "agent-c", "--archive"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Command != "agent" || cmd.Subcommand != "remove" {
+		t.Errorf("expected agent remove, got %q %q", cmd.Command, cmd.Subcommand)
+	}
+	if len(cmd.Args) != 1 || cmd.Args[0] != "agent-c" {
+		t.Errorf("expected Args=[agent-c], got %v", cmd.Args)
+	}
+	if !cmd.Archive {
+		t.Error("expected Archive=true")
+	}
+}
+
+func TestAgentCommands(t *testing.T) {
+	dataDir := t.TempDir()
+
+	if out, err := runCLI("agent", "add", "agent-c", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent add failed: %v\n%s", err, out)
+	}
+
+	reg, _ := registry.NewRegistry(dataDir)
+	agents, _ := reg.List()
+	if len(agents) != 3 {
+		t.Fatalf("expected defaults plus agent-c, got %v", agents)
+	}
+
+	// Removing an agent with queued work drains it
+	qMgr, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+	q, _ := qMgr.GetQueue("agent-c")
+	q.Enqueue(schema.NewUserMessage("agent-c", "pending"))
+
+	if out, err := runCLI("agent", "remove", "agent-c", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent remove failed: %v\n%s", err, out)
+	}
+	a, err := reg.Get("agent-c")
+	if err != nil || a.State != registry.StateDraining {
+		t.Fatalf("expected agent-c to be draining, got %v, %v", a, err)
+	}
+
+	// Archiving removes it at once and sets its queued messages aside; the
+	// one it is working on is left in flight
+	q.Enqueue(schema.NewUserMessage("agent-c", "working"))
+	q.Lease(time.Minute)
+	out, err := runCLI("agent", "remove", "agent-c", "--archive", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent remove --archive failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "1 message(s) archived; 1 in flight left alone") {
+		t.Errorf("expected the in-flight message reported:\n%s", out)
+	}
+	if _, err := reg.Get("agent-c"); err == nil {
+		t.Error("expected agent-c to be unregistered")
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("expected queue emptied, got %d", n)
+	}
+	archive, _ := qMgr.GetArchiveQueue("agent-c")
+	if n, _ := archive.Len(); n != 1 {
+		t.Errorf("expected 1 archived message, got %d", n)
+	}
+	if leases, _ := q.InFlight(); len(leases) != 1 {
+		t.Errorf("expected the lease left in flight, got %d", len(leases))
+	}
+
+	if err := runCLI("agent", "add", "broadcast", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected reserved name to be rejected")
+	}
+}
+
+func TestAgentProfileCommands(t *testing.T) {
+	dataDir := t.TempDir()
+
+	out, err := runCLI("agent", "add", "reviewer", "--data-dir", dataDir,
+		"--model", "opus", "--allowed-tools", "Read, Grep", "--max-turns", "5",
+		"--system-prompt", "You review code.", "--turn-timeout", "2m").CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent add failed: %v\n%s", err, out)
+	}
+
+	reg, _ := registry.NewRegistry(dataDir)
+	a, _ := reg.Get("reviewer")
+	p := a.Profile
+	if p.Model != "opus" || p.MaxTurns != 5 || p.SystemPrompt != "You review code." || p.TurnTimeout != 2*time.Minute {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+	if len(p.AllowedTools) != 2 || p.AllowedTools[0] != "Read" || p.AllowedTools[1] != "Grep" {
+		t.Errorf("expected allowed tools [Read Grep], got %v", p.AllowedTools)
+	}
+
+	// Only the given settings change
+	out, err = runCLI("agent", "set", "reviewer", "--model", "haiku", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent set failed: %v\n%s", err, out)
+	}
+	a, _ = reg.Get("reviewer")
+	if a.Profile.Model != "haiku" || a.Profile.MaxTurns != 5 || len(a.Profile.AllowedTools) != 2 {
+		t.Errorf("expected only the model to change, got %+v", a.Profile)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"bot {{message}}", []string{"bot", "{{message}}"}},
+		{`python3 "my bot.py" --name 'agent c'`, []string{"python3", "my bot.py", "--name", "agent c"}},
+		{`echo a\ b ""`, []string{"echo", "a b", ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.in, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
+
+	for _, in := range []string{`bot "unclosed`, `bot \`} {
+		if _, err := splitCommand(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestAgentCommandProfile(t *testing.T) {
+	dataDir := t.TempDir()
+
+	out, err := runCLI("agent", "add", "scripted", "--data-dir", dataDir,
+		"--command", "python3 bot.py --session {{session_id}}", "--command-stdin",
+		"--command-output", "json", "--command-map", "response=$.reply, cost=$.usage.cost").CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent add failed: %v\n%s", err, out)
+	}
+
+	reg, _ := registry.NewRegistry(dataDir)
+	a, _ := reg.Get("scripted")
+	c := a.Profile.Command
+	if c == nil || len(c.Argv) != 4 || c.Argv[3] != "{{session_id}}" {
+		t.Fatalf("unexpected command: %+v", c)
+	}
+	if !c.Stdin || c.Output != registry.OutputJSON || c.Fields["response"] != "$.reply" || c.Fields["cost"] != "$.usage.cost" {
+		t.Errorf("unexpected command settings: %+v", c)
+	}
+
+	if err := runCLI("agent", "set", "scripted", "--command-map", "mood=$.mood", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected unknown result field to be rejected")
+	}
+
+	// A new command line keeps the other command settings
+	if out, err := runCLI("agent", "set", "scripted", "--command", "python3 bot2.py", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent set failed: %v\n%s", err, out)
+	}
+	a, _ = reg.Get("scripted")
+	if c := a.Profile.Command; c == nil || c.Argv[1] != "bot2.py" || !c.Stdin || c.Output != registry.OutputJSON || c.Fields["cost"] != "$.usage.cost" {
+		t.Errorf("expected only argv replaced, got %+v", c)
+	}
+
+	// An empty command switches the agent back to claude
+	if out, err := runCLI("agent", "set", "scripted", "--command", "", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent set failed: %v\n%s", err, out)
+	}
+	a, _ = reg.Get("scripted")
+	if a.Profile.Command != nil {
+		t.Errorf("expected command cleared, got %+v", a.Profile.Command)
+	}
+}
+
+func TestAgentEndpointProfile(t *testing.T) {
+	dataDir := t.TempDir()
+
+	out, err := runCLI("agent", "add", "offline", "--endpoint", "http://localhost:8080",
+		"--model", "test-model", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent add failed: %v\n%s", err, out)
+	}
+	reg, _ := registry.NewRegistry(dataDir)
+	a, _ := reg.Get("offline")
+	if a.Profile.Endpoint != "http://localhost:8080" || a.Profile.Model != "test-model" {
+		t.Errorf("unexpected profile: %+v", a.Profile)
+	}
+
+	if err := runCLI("agent", "add", "bad", "--endpoint", "localhost:8080", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected URL without scheme to be rejected")
+	}
+	if err := runCLI("agent", "add", "nameless", "--endpoint", "http://localhost:8080", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected endpoint without a model to be rejected")
+	}
+	if err := runCLI("agent", "set", "offline", "--model", "", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected clearing the model of an endpoint agent to be rejected")
+	}
+	if err := runCLI("agent", "set", "offline", "--command", "bot", "--data-dir", dataDir).Run(); err == nil {
+		t.Error("expected command and endpoint together to be rejected")
+	}
+}
+
+func TestHTTPExecutorFromProfile(t *testing.T) {
+	exec := httpExecutor(registry.Profile{
+		Endpoint:           "http://localhost:8080",
+		Model:              "test-model",
+		SystemPrompt:       "You review code.",
+		AppendSystemPrompt: "Be brief.",
+	}, nil)
+	if exec.BaseURL != "http://localhost:8080" || exec.Model != "test-model" {
+		t.Errorf("unexpected executor: %+v", exec)
+	}
+	if exec.System != "You review code.\n\nBe brief." {
+		t.Errorf("expected both prompts joined, got %q", exec.System)
+	}
+}
+
+func TestAgentBudgetStatus(t *testing.T) {
+	dataDir := t.TempDir()
+
+	if out, err := runCLI("agent", "set", "agent-a", "--budget", "0.5", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent set failed: %v\n%s", err, out)
+	}
+	reg, _ := registry.NewRegistry(dataDir)
+	a, _ := reg.Get("agent-a")
+	if a.Profile.Budget != 0.5 {
+		t.Fatalf("expected budget 0.5, got %v", a.Profile.Budget)
+	}
+
+	ledger, _ := cost.NewLedger(dataDir)
+	ledger.StartRun(2, 0)
+	ledger.Add("agent-a", 0.6)
+	ledger.Add("agent-b", 0.1)
+
+	out, err := runCLI("status", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("status failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"This run: $0.7000 of $2.00 budget, 2 turn(s)",
+		"cost: $0.6000 of $0.50 budget, 1 turn(s) (paused)",
+		"cost: $0.1000, 1 turn(s)\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in status output:\n%s", want, out)
+		}
+	}
+}
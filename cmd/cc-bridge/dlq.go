@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func runDLQ(cmd *Command) {
+	qMgr, err := queue.NewManager(filepath.Join(cmd.DataDir, "queues"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create queue manager: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch cmd.Subcommand {
+	case "list":
+		runDLQList(cmd, qMgr)
+	case "show":
+		runDLQShow(cmd, qMgr)
+	case "requeue":
+		runDLQRequeue(cmd, qMgr)
+	}
+}
+
+func runDLQList(cmd *Command, qMgr *queue.Manager) {
+	agents, err := deadLetterAgents(cmd, qMgr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list agents: %v\n", err)
+		os.Exit(1)
+	}
+
+	found := false
+	for _, agent := range agents {
+		dlq, err := qMgr.GetDeadLetterQueue(agent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get dead-letter queue: %v\n", err)
+			os.Exit(1)
+		}
+		msgs, err := dlq.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list dead letters for %s: %v\n", agent, err)
+			os.Exit(1)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		found = true
+		fmt.Printf("%s: %d dead letter(s)\n", agent, len(msgs))
+		for _, msg := range msgs {
+			fmt.Printf("  %s  %s  from %s  attempts %d/%d  error: %s\n",
+				msg.ID, msg.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				msg.From, msg.Attempts, msg.MaxAttempts, msg.Payload.Metadata["error"])
+		}
+	}
+
+	if !found {
+		fmt.Println("No dead letters")
+	}
+}
+
+func runDLQShow(cmd *Command, qMgr *queue.Manager) {
+	if len(cmd.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: dlq show requires a message ID\n")
+		os.Exit(1)
+	}
+
+	_, msg, err := findDeadLetter(cmd, qMgr, cmd.Args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, _ := json.MarshalIndent(msg, "", "  ")
+	fmt.Println(string(data))
+}
+
+func runDLQRequeue(cmd *Command, qMgr *queue.Manager) {
+	if len(cmd.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: dlq requeue requires at least one message ID\n")
+		os.Exit(1)
+	}
+
+	for _, id := range cmd.Args {
+		agent, msg, err := findDeadLetter(cmd, qMgr, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Start over with a fresh set of attempts, keeping the last error for reference
+		if lastErr := msg.Payload.Metadata["error"]; lastErr != "" {
+			delete(msg.Payload.Metadata, "error")
+			msg.WithMetadata("last_error", lastErr)
+		}
+		msg.Attempts = 0
+		msg.MaxAttempts = 0
+		msg.NotBefore = nil
+
+		q, err := qMgr.GetQueue(agent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+			os.Exit(1)
+		}
+		if err := q.Enqueue(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to requeue %s: %v\n", id, err)
+			os.Exit(1)
+		}
+
+		dlq, _ := qMgr.GetDeadLetterQueue(agent)
+		if err := dlq.Remove(id); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: requeued %s but failed to remove dead letter: %v\n", id, err)
+		}
+		recordEnqueued(cmd, msg)
+
+		fmt.Printf("Requeued %s to %s\n", id, agent)
+	}
+}
+
+// selectedAgents returns the agent named by --agent, or every agent with a queue
+func selectedAgents(cmd *Command, qMgr *queue.Manager) ([]string, error) {
+	if cmd.Agent != "" {
+		return []string{cmd.Agent}, nil
+	}
+	return qMgr.ListAgents()
+}
+
+// deadLetterAgents is selectedAgents without the human inbox and the
+// broadcast queue, which never dead-letter anything. Asking them for a
+// dead-letter queue would create one.
+func deadLetterAgents(cmd *Command, qMgr *queue.Manager) ([]string, error) {
+	agents, err := selectedAgents(cmd, qMgr)
+	if err != nil || cmd.Agent != "" {
+		return agents, err
+	}
+	return slices.DeleteFunc(agents, func(id string) bool {
+		return id == schema.Human || id == schema.Broadcast
+	}), nil
+}
+
+// findDeadLetter looks up a dead letter by ID, searching every agent unless
+// --agent narrows it down
+func findDeadLetter(cmd *Command, qMgr *queue.Manager, id string) (string, *schema.Message, error) {
+	agents, err := deadLetterAgents(cmd, qMgr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	for _, agent := range agents {
+		dlq, err := qMgr.GetDeadLetterQueue(agent)
+		if err != nil {
+			return "", nil, err
+		}
+		msg, err := dlq.Get(id)
+		if err == nil {
+			return agent, msg, nil
+		}
+		if !errors.Is(err, queue.ErrNotFound) {
+			return "", nil, err
+		}
+	}
+	return "", nil, fmt.Errorf("dead letter %s not found in %s", id, strings.Join(agents, ", "))
+}
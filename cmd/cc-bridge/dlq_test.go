@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestParseArgs_DLQList(t *testing.T) {
+	cmd, err := ParseArgs([]string{"dlq", "list", "--agent", "agent-a"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Command != "dlq" || cmd.Subcommand != "list" {
+		t.Errorf("expected dlq list, got %q %q", cmd.Command, cmd.Subcommand)
+	}
+	if cmd.Agent != "agent-a" {
+		t.Errorf("expected Agent='agent-a', got %q", cmd.Agent)
+	}
+}
+
+func TestParseArgs_DLQRequeueFlagsAfterIDs(t *testing.T) {
+	cmd, err := ParseArgs([]string{"dlq", "requeue", "id-1", "id-2", "--agent", "agent-b"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if len(cmd.Args) != 2 || cmd.Args[0] != "id-1" || cmd.Args[1] != "id-2" {
+		t.Errorf("expected Args=[id-1 id-2], got %v", cmd.Args)
+	}
+	if cmd.Agent != "agent-b" {
+		t.Errorf("expected Agent='agent-b', got %q", cmd.Agent)
+	}
+}
+
+func TestParseArgs_DLQMissingSubcommand(t *testing.T) {
+	if _, err := ParseArgs([]string{"dlq"}); err == nil {
+		t.Error("expected error for missing subcommand")
+	}
+	if _, err := ParseArgs([]string{"dlq", "explode"}); err == nil {
+		t.Error("expected error for unknown subcommand")
+	}
+}
+
+func TestParseArgs_RetryFlags(t *testing.T) {
+	cmd, err := ParseArgs([]string{"start", "--max-attempts", "5", "--retry-backoff", "2s", "--retry-max-backoff", "1m"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.MaxAttempts != 5 {
+		t.Errorf("expected MaxAttempts=5, got %d", cmd.MaxAttempts)
+	}
+	if cmd.RetryBackoff != 2*time.Second || cmd.RetryMaxBackoff != time.Minute {
+		t.Errorf("unexpected backoff: %v, %v", cmd.RetryBackoff, cmd.RetryMaxBackoff)
+	}
+}
+
+func TestDLQCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	queues := filepath.Join(dataDir, "queues")
+	for _, id := range []string{schema.Human, schema.Broadcast, schema.AgentA} {
+		os.MkdirAll(filepath.Join(queues, id), 0755)
+	}
+
+	if out, err := runCLI("dlq", "list", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("dlq list failed: %v\n%s", err, out)
+	}
+	for _, id := range []string{schema.Human, schema.Broadcast} {
+		if _, err := os.Stat(filepath.Join(queues, id, "dead")); !os.IsNotExist(err) {
+			t.Errorf("expected no dead-letter queue created for %s, got %v", id, err)
+		}
+	}
+
+	// A dead letter that can't be read is an error, not a missing message
+	os.WriteFile(filepath.Join(queues, schema.AgentA, "dead", "1_broken.json"), []byte("{"), 0644)
+	out, err := runCLI("dlq", "show", "broken", "--data-dir", dataDir).CombinedOutput()
+	if err == nil || strings.Contains(string(out), "not found") {
+		t.Errorf("expected the read error reported, got %v:\n%s", err, out)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// runFork registers a new agent that continues from another agent's current
+// conversation. It takes the source agent's profile, overlaid with any
+// profile flags, so the branch can try a different model or prompt.
+func runFork(cmd *Command) {
+	if cmd.Agent == "" {
+		fmt.Fprintf(os.Stderr, "Error: --agent is required\n")
+		os.Exit(1)
+	}
+	if cmd.As == "" {
+		fmt.Fprintf(os.Stderr, "Error: --as is required\n")
+		os.Exit(1)
+	}
+	if err := registry.ValidateID(cmd.As); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	reg, err := openRegistry(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open agent registry: %v\n", err)
+		os.Exit(1)
+	}
+	src, err := reg.Get(cmd.Agent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := reg.Get(cmd.As); err == nil {
+		fmt.Fprintf(os.Stderr, "Error: agent %s already exists\n", cmd.As)
+		os.Exit(1)
+	}
+	// claude keeps sessions per working directory, so a fork of a claude
+	// agent runs where its source does, sharing its workspace if it has one
+	base := src.Profile
+	if base.Endpoint == "" {
+		base.WorkDir, base.Workspace = agentDir(cmd, src.ID, src.Profile), nil
+	}
+	profile, err := applyProfileFlags(cmd, base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if profile.Endpoint == "" && agentDir(cmd, cmd.As, profile) != base.WorkDir {
+		fmt.Fprintf(os.Stderr, "Error: %s must run in %s to resume %s's conversation\n",
+			cmd.As, displayDir(base.WorkDir), cmd.Agent)
+		os.Exit(1)
+	}
+
+	if err := ensureWorkspace(cmd, cmd.As, profile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	sMgr, err := session.NewManager(filepath.Join(cmd.DataDir, "sessions"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create session manager: %v\n", err)
+		os.Exit(1)
+	}
+	if err := sMgr.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The session is written before the agent is registered, so a running
+	// broker finds it when it starts the new agent's worker
+	sess, err := sMgr.Fork(cmd.Agent, cmd.As)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := reg.Add(cmd.As, profile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Forked %s as %s at turn %d (session %s)\n", cmd.Agent, cmd.As, sess.TurnNumber, sess.SessionID)
+	fmt.Printf("%s's next turn branches into a new session; %s is unchanged\n", cmd.As, cmd.Agent)
+}
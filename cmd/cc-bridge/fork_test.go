@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+func TestFork(t *testing.T) {
+	dataDir := t.TempDir()
+	runCLI("agent", "set", "agent-a", "--model", "sonnet", "--data-dir", dataDir).Run()
+
+	sMgr, _ := session.NewManager(filepath.Join(dataDir, "sessions"))
+	sMgr.CreateSession("agent-a")
+
+	// Nothing to branch from yet
+	if out, err := runCLI("fork", "--agent", "agent-a", "--as", "agent-a2", "--data-dir", dataDir).CombinedOutput(); err == nil {
+		t.Fatalf("expected fork of an unstarted session to fail:\n%s", out)
+	}
+
+	sMgr.SetSessionID("agent-a", "session-1")
+	out, err := runCLI("fork", "--agent", "agent-a", "--as", "agent-a2", "--model", "opus", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("fork failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Forked agent-a as agent-a2 at turn 0 (session session-1)") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	reg, _ := registry.NewRegistry(dataDir)
+	a, err := reg.Get("agent-a2")
+	if err != nil || a.Profile.Model != "opus" {
+		t.Errorf("expected agent-a2 registered with the overridden model, got %+v, %v", a, err)
+	}
+	// claude could not resume the conversation from a workspace of its own
+	if a.Profile.Workspace != nil || a.Profile.WorkDir != filepath.Join(dataDir, "workspaces", "agent-a") {
+		t.Errorf("expected agent-a2 to run in agent-a's workspace, got %+v", a.Profile)
+	}
+	if out, err := runCLI("fork", "--agent", "agent-a", "--as", "agent-a3", "--workspace", "--data-dir", dataDir).CombinedOutput(); err == nil {
+		t.Errorf("expected a fork into another directory to fail:\n%s", out)
+	}
+
+	loaded, _ := session.NewManager(filepath.Join(dataDir, "sessions"))
+	loaded.Load()
+	sess, _ := loaded.GetSession("agent-a2")
+	if sess == nil || sess.SessionID != "session-1" || !sess.PendingFork {
+		t.Errorf("expected pending fork of session-1, got %+v", sess)
+	}
+
+	if out, err := runCLI("fork", "--agent", "agent-a", "--as", "agent-a2", "--data-dir", dataDir).CombinedOutput(); err == nil {
+		t.Errorf("expected forking onto an existing agent to fail:\n%s", out)
+	}
+
+	out, _ = runCLI("status", "--data-dir", dataDir).CombinedOutput()
+	if !strings.Contains(string(out), "forked from agent-a") {
+		t.Errorf("expected status to show the fork:\n%s", out)
+	}
+}
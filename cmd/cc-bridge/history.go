@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func runHistory(cmd *Command) {
+	now := time.Now().UTC()
+	since, err := ParseTimeArg(cmd.Since, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --since: %v\n", err)
+		os.Exit(1)
+	}
+	until, err := ParseTimeArg(cmd.Until, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --until: %v\n", err)
+		os.Exit(1)
+	}
+
+	hist, err := history.NewStore(filepath.Join(cmd.DataDir, "history"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+		os.Exit(1)
+	}
+
+	records, err := hist.Query(history.Filter{
+		Agent: cmd.Agent,
+		Peer:  cmd.Peer,
+		From:  cmd.From,
+		Type:  cmd.Type,
+		Since: since,
+		Until: until,
+	})
+	if err != nil {
+		var bad *history.MalformedError
+		if !errors.As(err, &bad) {
+			fmt.Fprintf(os.Stderr, "Failed to query history: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No matching history")
+		return
+	}
+
+	for _, r := range records {
+		fmt.Println(formatRecord(r))
+	}
+}
+
+// historyPollInterval is how often watch and send --wait check the history
+// for new records
+const historyPollInterval = 100 * time.Millisecond
+
+// formatRecord renders a history record as one line of text. Failures carry
+// their error.
+func formatRecord(r *history.Record) string {
+	msg := r.Message
+	line := fmt.Sprintf("%s %-13s %s -> %s (%s): %s",
+		r.Time.Local().Format("2006-01-02 15:04:05"),
+		r.Event, msg.From, msg.To, msg.Type, msg.Payload.Text)
+	if r.Event == history.EventFailed || r.Event == history.EventDeadLettered {
+		line += " [error: " + msg.Payload.Metadata["error"] + "]"
+	}
+	return line
+}
+
+// recordEnqueued appends an enqueue event for messages written by the CLI
+func recordEnqueued(cmd *Command, msg *schema.Message) {
+	hist, err := history.NewStore(filepath.Join(cmd.DataDir, "history"))
+	if err == nil {
+		err = hist.Append(history.EventEnqueued, msg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record history: %v\n", err)
+	}
+}
+
+// ParseTimeArg accepts an RFC3339 timestamp, a YYYY-MM-DD date, or a
+// duration meaning that long before now. An empty string yields zero time.
+func ParseTimeArg(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseArgs_History(t *testing.T) {
+	args := []string{"history", "--agent", "agent-a", "--peer", "agent-b", "--type", "inject", "--since", "1h"}
+	cmd, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Command != "history" {
+		t.Errorf("expected command='history', got %q", cmd.Command)
+	}
+	if cmd.Agent != "agent-a" || cmd.Peer != "agent-b" || cmd.Type != "inject" {
+		t.Errorf("unexpected filters: agent=%q peer=%q type=%q", cmd.Agent, cmd.Peer, cmd.Type)
+	}
+	if cmd.Since != "1h" {
+		t.Errorf("expected Since='1h', got %q", cmd.Since)
+	}
+}
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2025, 12, 10, 12, 0, 0, 0, time.UTC)
+
+	got, err := ParseTimeArg("", now)
+	if err != nil || !got.IsZero() {
+		t.Errorf("expected zero time for empty arg, got %v (%v)", got, err)
+	}
+
+	got, err = ParseTimeArg("90m", now)
+	if err != nil {
+		t.Fatalf("duration failed: %v", err)
+	}
+	if !got.Equal(now.Add(-90 * time.Minute)) {
+		t.Errorf("expected 90m ago, got %v", got)
+	}
+
+	got, err = ParseTimeArg("2025-12-10T06:00:00Z", now)
+	if err != nil {
+		t.Fatalf("RFC3339 failed: %v", err)
+	}
+	if got.Hour() != 6 {
+		t.Errorf("expected hour 6, got %v", got)
+	}
+
+	if _, err := ParseTimeArg("2025-12-01", now); err != nil {
+		t.Errorf("date failed: %v", err)
+	}
+
+	if _, err := ParseTimeArg("yesterday-ish", now); err == nil {
+		t.Error("expected error for unrecognized time")
+	}
+}
@@ -7,24 +7,76 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/binaryphile/cc-bridge/internal/broker"
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/history"
 	"github.com/binaryphile/cc-bridge/internal/queue"
 	"github.com/binaryphile/cc-bridge/internal/schema"
 	"github.com/binaryphile/cc-bridge/internal/session"
+	"github.com/binaryphile/cc-bridge/internal/workspace"
 )
 
 // Command represents a parsed CLI command
 type Command struct {
-	Command      string
-	DataDir      string
-	PollInterval time.Duration
-	To           string
-	As           string
-	Message      string
+	Command       string
+	Subcommand    string
+	Args          []string
+	DataDir       string
+	PollInterval  time.Duration
+	To            string
+	As            string
+	Message       string
+	Route         bool
+	MaxHops       int
+	MaxConcurrent int
+	Watch         bool
+	Agent         string
+	Peer          string
+	From          string
+	Type          string
+	Since         string
+	Until         string
+	Archive       bool
+	Stream        bool
+	Batch         int
+	Wait          bool
+	Timeout       time.Duration
+	JSON          bool
+
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	LeaseTimeout    time.Duration
+	TurnTimeout     time.Duration
+	Budget          float64
+	RunBudget       float64
+
+	// Agent profile settings for "agent add" and "agent set"
+	Model              string
+	SystemPrompt       string
+	AppendSystemPrompt string
+	AllowedTools       string
+	DisallowedTools    string
+	PermissionMode     string
+	MaxTurns           int
+	WorkDir            string
+	Workspace          bool
+	WorkspaceRepo      string
+
+	// Settings for agents backed by another program instead of claude
+	AgentCommand  string
+	CommandStdin  bool
+	CommandOutput string
+	CommandMap    string
+	Endpoint      string
+
+	// SetFlags holds the names of flags given explicitly on the command line
+	SetFlags map[string]bool
 }
 
 // DefaultDataDir returns the default data directory
@@ -42,33 +94,114 @@ func ParseArgs(args []string) (*Command, error) {
 		return nil, fmt.Errorf("no command specified")
 	}
 
+	retry := broker.DefaultRetryPolicy()
 	cmd := &Command{
-		Command:      args[0],
-		DataDir:      DefaultDataDir(),
-		PollInterval: time.Second,
+		Command:         args[0],
+		DataDir:         DefaultDataDir(),
+		PollInterval:    time.Second,
+		MaxHops:         10,
+		Watch:           true,
+		MaxAttempts:     retry.MaxAttempts,
+		RetryBackoff:    retry.InitialBackoff,
+		RetryMaxBackoff: retry.MaxBackoff,
+		LeaseTimeout:    broker.DefaultLeaseTimeout,
 	}
 
 	validCommands := map[string]bool{
-		"start":  true,
-		"status": true,
-		"send":   true,
-		"inject": true,
+		"start":     true,
+		"status":    true,
+		"send":      true,
+		"inject":    true,
+		"history":   true,
+		"dlq":       true,
+		"agent":     true,
+		"fork":      true,
+		"workspace": true,
+		"watch":     true,
+		"queue":     true,
+	}
+
+	// Commands that take a subcommand, e.g. "dlq list"
+	subcommands := map[string][]string{
+		"dlq":       {"list", "show", "requeue"},
+		"agent":     {"add", "set", "remove", "list"},
+		"workspace": {"diff"},
+		"queue":     {"ls", "peek", "show", "rm", "purge", "move"},
 	}
 
 	if !validCommands[cmd.Command] {
 		return nil, fmt.Errorf("invalid command: %s", cmd.Command)
 	}
 
+	rest := args[1:]
+	if subs, ok := subcommands[cmd.Command]; ok {
+		if len(rest) == 0 || !slices.Contains(subs, rest[0]) {
+			return nil, fmt.Errorf("%s requires a subcommand: %s", cmd.Command, strings.Join(subs, ", "))
+		}
+		cmd.Subcommand = rest[0]
+		rest = rest[1:]
+	}
+
 	// Parse flags based on command
 	fs := flag.NewFlagSet(cmd.Command, flag.ContinueOnError)
 	fs.StringVar(&cmd.DataDir, "data-dir", cmd.DataDir, "data directory")
 	fs.DurationVar(&cmd.PollInterval, "poll-interval", cmd.PollInterval, "poll interval")
 	fs.StringVar(&cmd.To, "to", "", "target agent")
 	fs.StringVar(&cmd.As, "as", "", "agent to impersonate")
+	fs.BoolVar(&cmd.Route, "route", false, "deliver responses to the addressed agent's queue")
+	fs.IntVar(&cmd.MaxHops, "max-hops", cmd.MaxHops, "maximum routed replies per conversation (0 = unlimited)")
+	fs.IntVar(&cmd.MaxConcurrent, "max-concurrent", 0, "maximum claude processes running at once (0 = unlimited)")
+	fs.BoolVar(&cmd.Watch, "watch", cmd.Watch, "wake on queue file events instead of waiting for the next poll")
+	fs.StringVar(&cmd.Agent, "agent", "", "agent to filter or operate on")
+	fs.StringVar(&cmd.Peer, "peer", "", "other side of the conversation to filter on")
+	fs.StringVar(&cmd.From, "from", "", "sender to filter on")
+	fs.StringVar(&cmd.Type, "type", "", "message type to filter on")
+	fs.StringVar(&cmd.Since, "since", "", "start of time range (RFC3339, date, or duration ago)")
+	fs.StringVar(&cmd.Until, "until", "", "end of time range (RFC3339, date, or duration ago)")
+	fs.IntVar(&cmd.MaxAttempts, "max-attempts", cmd.MaxAttempts, "attempts per message before it is dead-lettered")
+	fs.DurationVar(&cmd.RetryBackoff, "retry-backoff", cmd.RetryBackoff, "delay before the first retry; doubles on each attempt")
+	fs.DurationVar(&cmd.RetryMaxBackoff, "retry-max-backoff", cmd.RetryMaxBackoff, "maximum delay between retries")
+	fs.DurationVar(&cmd.LeaseTimeout, "lease-timeout", cmd.LeaseTimeout, "how long a message stays in flight before it is redelivered")
+	fs.DurationVar(&cmd.TurnTimeout, "turn-timeout", 0, "stop a turn that runs longer than this and retry it (0 = no limit)")
+	fs.Float64Var(&cmd.Budget, "budget", 0, "USD an agent may spend before it is paused (0 = no limit)")
+	fs.Float64Var(&cmd.RunBudget, "run-budget", 0, "USD the broker may spend in this run before it stops (0 = no limit)")
+	fs.BoolVar(&cmd.Stream, "stream", false, "capture tool calls and other intermediate events with stream-json output")
+	fs.StringVar(&cmd.Model, "model", "", "model for the agent's claude process")
+	fs.StringVar(&cmd.SystemPrompt, "system-prompt", "", "system prompt replacing claude's default")
+	fs.StringVar(&cmd.AppendSystemPrompt, "append-system-prompt", "", "text appended to claude's default system prompt")
+	fs.StringVar(&cmd.AllowedTools, "allowed-tools", "", "comma-separated tools the agent may use without asking")
+	fs.StringVar(&cmd.DisallowedTools, "disallowed-tools", "", "comma-separated tools the agent may not use")
+	fs.StringVar(&cmd.PermissionMode, "permission-mode", "", "claude permission mode (default, acceptEdits, plan, bypassPermissions)")
+	fs.IntVar(&cmd.MaxTurns, "max-turns", 0, "agentic turns per message (0 = 1)")
+	fs.StringVar(&cmd.WorkDir, "work-dir", "", "working directory for the agent's claude process")
+	fs.BoolVar(&cmd.Workspace, "workspace", false, "run the agent in its own directory under the data dir (default for new agents; --workspace=false opts out)")
+	fs.StringVar(&cmd.WorkspaceRepo, "workspace-repo", "", "git repository to check out as the agent's workspace worktree")
+	fs.StringVar(&cmd.AgentCommand, "command", "", "run this program for the agent instead of claude; may use {{session_id}} and {{message}}")
+	fs.BoolVar(&cmd.CommandStdin, "command-stdin", false, "also write the message to the command's stdin")
+	fs.StringVar(&cmd.CommandOutput, "command-output", "", "how to read the command's stdout: text or json")
+	fs.StringVar(&cmd.CommandMap, "command-map", "", "comma-separated field=path mappings for json output, e.g. response=$.reply")
+	fs.StringVar(&cmd.Endpoint, "endpoint", "", "base URL of a Messages API server to use for the agent instead of claude (requires --model)")
+	fs.IntVar(&cmd.Batch, "batch", 0, "answer up to this many queued messages per agent in one turn (0 = one at a time)")
+	fs.BoolVar(&cmd.Archive, "archive", false, "move queued messages aside instead of draining or deleting them (agent remove, queue purge)")
+	fs.BoolVar(&cmd.Wait, "wait", false, "wait for the agent's reply and print it")
+	fs.DurationVar(&cmd.Timeout, "timeout", 0, "give up waiting for a reply after this long (0 = wait indefinitely)")
+	fs.BoolVar(&cmd.JSON, "json", false, "print output as JSON (watch: one record per line)")
+
+	// Subcommands take positional arguments with flags on either side
+	if cmd.Subcommand != "" {
+		positional, err := parseInterleaved(fs, rest)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = positional
+		cmd.SetFlags = setFlags(fs)
+		return cmd, nil
+	}
 
-	if err := fs.Parse(args[1:]); err != nil {
+	if err := fs.Parse(rest); err != nil {
 		return nil, err
 	}
+	cmd.SetFlags = setFlags(fs)
 
 	// Collect remaining args as message
 	if fs.NArg() > 0 {
@@ -78,12 +211,37 @@ func ParseArgs(args []string) (*Command, error) {
 	return cmd, nil
 }
 
+// setFlags returns the names of the flags that were given explicitly
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// parseInterleaved parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments in order
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
 func main() {
 	cmd, err := ParseArgs(os.Args[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		fmt.Fprintf(os.Stderr, "Usage: cc-bridge <command> [options]\n")
-		fmt.Fprintf(os.Stderr, "Commands: start, status, send, inject\n")
+		fmt.Fprintf(os.Stderr, "Commands: start, status, send, inject, history, dlq, agent, fork, workspace, watch, queue\n")
 		os.Exit(1)
 	}
 
@@ -96,6 +254,20 @@ func main() {
 		runSend(cmd)
 	case "inject":
 		runInject(cmd)
+	case "history":
+		runHistory(cmd)
+	case "dlq":
+		runDLQ(cmd)
+	case "agent":
+		runAgentCmd(cmd)
+	case "fork":
+		runFork(cmd)
+	case "workspace":
+		runWorkspace(cmd)
+	case "watch":
+		runWatch(cmd)
+	case "queue":
+		runQueueCmd(cmd)
 	}
 }
 
@@ -103,6 +275,12 @@ func runStart(cmd *Command) {
 	fmt.Printf("Starting cc-bridge broker...\n")
 	fmt.Printf("Data directory: %s\n", cmd.DataDir)
 	fmt.Printf("Poll interval: %v\n", cmd.PollInterval)
+	if cmd.Route {
+		fmt.Printf("Routing: enabled (max hops: %d)\n", cmd.MaxHops)
+	}
+	if cmd.RunBudget > 0 || cmd.Budget > 0 {
+		fmt.Printf("Budgets: %s per run, %s per agent\n", budgetString(cmd.RunBudget), budgetString(cmd.Budget))
+	}
 
 	// Initialize components
 	qMgr, err := queue.NewManager(filepath.Join(cmd.DataDir, "queues"))
@@ -123,15 +301,57 @@ func runStart(cmd *Command) {
 	}
 
 	executor := broker.NewClaudeExecutor()
+	executor.Stream = cmd.Stream
 	b, err := broker.NewBroker(qMgr, sMgr, executor)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create broker: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Initialize agents
-	b.InitializeAgent(schema.AgentA)
-	b.InitializeAgent(schema.AgentB)
+	// Agents come from the registry and may change while the broker runs
+	reg, err := openRegistry(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open agent registry: %v\n", err)
+		os.Exit(1)
+	}
+	b.SetRegistry(reg)
+	// An agent without its workspace fails its turns, but the rest still run
+	if err := ensureWorkspaces(cmd, reg); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to create workspaces: %v\n", err)
+	}
+	wsMgr, err := workspace.NewManager(filepath.Join(cmd.DataDir, "workspaces"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create workspace manager: %v\n", err)
+		os.Exit(1)
+	}
+	b.SetExecutorFactory(executorFactory(cmd, sMgr, wsMgr))
+	b.SetRouting(cmd.Route, cmd.MaxHops)
+	b.SetMaxConcurrent(cmd.MaxConcurrent)
+	b.SetRetryPolicy(broker.RetryPolicy{
+		MaxAttempts:    cmd.MaxAttempts,
+		InitialBackoff: cmd.RetryBackoff,
+		MaxBackoff:     cmd.RetryMaxBackoff,
+	})
+	b.SetLeaseTimeout(cmd.LeaseTimeout)
+	b.SetTurnTimeout(cmd.TurnTimeout)
+	b.SetBudget(cmd.Budget)
+	b.SetRunBudget(cmd.RunBudget)
+
+	ledger, err := cost.NewLedger(cmd.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open cost ledger: %v\n", err)
+		os.Exit(1)
+	}
+	b.SetCostLedger(ledger)
+	b.SetBatchSize(cmd.Batch)
+	b.SetHeartbeatFile(filepath.Join(cmd.DataDir, "broker.json"))
+
+	hist, err := history.NewStore(filepath.Join(cmd.DataDir, "history"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: history disabled: %v\n", err)
+	} else {
+		b.SetHistory(hist)
+	}
 
 	// Set response handler
 	b.SetResponseHandler(func(msg *schema.Message) {
@@ -140,11 +360,21 @@ func runStart(cmd *Command) {
 			msg.From, msg.To, msg.Payload.Text)
 	})
 
+	b.SetErrorHandler(func(agent string, err error) {
+		fmt.Fprintf(os.Stderr, "[%s] %s error: %v\n", time.Now().Format("15:04:05"), agent, err)
+	})
+
 	// Handle shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	if cmd.Watch {
+		if err := qMgr.Watch(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; falling back to polling\n", err)
+		}
+	}
+
 	go func() {
 		<-sigCh
 		fmt.Println("\nShutting down...")
@@ -158,32 +388,18 @@ func runStart(cmd *Command) {
 	b.Run(ctx, cmd.PollInterval)
 }
 
-func runStatus(cmd *Command) {
-	sMgr, err := session.NewManager(filepath.Join(cmd.DataDir, "sessions"))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create session manager: %v\n", err)
-		os.Exit(1)
-	}
-
-	if err := sMgr.Load(); err != nil {
-		fmt.Fprintf(os.Stderr, "No sessions found: %v\n", err)
-		os.Exit(0)
-	}
-
-	sessions := sMgr.ListSessions()
-	if len(sessions) == 0 {
-		fmt.Println("No active sessions")
-		return
+func budgetString(usd float64) string {
+	if usd <= 0 {
+		return "unlimited"
 	}
+	return fmt.Sprintf("$%.2f", usd)
+}
 
-	fmt.Println("Active sessions:")
-	for _, s := range sessions {
-		status := "not started"
-		if s.SessionID != "" {
-			status = fmt.Sprintf("turn %d", s.TurnNumber)
-		}
-		fmt.Printf("  %s: %s\n", s.AgentID, status)
+func spendSummary(spent, budget float64) string {
+	if budget <= 0 {
+		return fmt.Sprintf("$%.4f", spent)
 	}
+	return fmt.Sprintf("$%.4f of $%.2f budget", spent, budget)
 }
 
 func runSend(cmd *Command) {
@@ -195,6 +411,10 @@ func runSend(cmd *Command) {
 		fmt.Fprintf(os.Stderr, "Error: message is required\n")
 		os.Exit(1)
 	}
+	if cmd.SetFlags["timeout"] && !cmd.Wait {
+		fmt.Fprintf(os.Stderr, "Error: --timeout requires --wait\n")
+		os.Exit(1)
+	}
 
 	qMgr, err := queue.NewManager(filepath.Join(cmd.DataDir, "queues"))
 	if err != nil {
@@ -209,12 +429,44 @@ func runSend(cmd *Command) {
 		os.Exit(1)
 	}
 
+	// Follow the history from before the message is queued, so a fast reply
+	// is not missed
+	var cursor *history.Cursor
+	if cmd.Wait {
+		hist, err := history.NewStore(filepath.Join(cmd.DataDir, "history"))
+		if err == nil {
+			cursor, err = hist.Cursor()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if err := q.Enqueue(msg); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to send message: %v\n", err)
 		os.Exit(1)
 	}
+	recordEnqueued(cmd, msg)
 
-	fmt.Printf("Message sent to %s\n", cmd.To)
+	if cmd.Wait {
+		result, err := waitForReply(cursor, msg.ID, cmd.Timeout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to wait for reply: %v\n", err)
+			os.Exit(1)
+		}
+		printSendResult(cmd, result)
+		return
+	}
+
+	switch {
+	case cmd.JSON:
+		printSendResult(cmd, &sendResult{ID: msg.ID, Status: statusSent})
+	case cmd.To == schema.Broadcast:
+		fmt.Printf("Message broadcast to all agents (id %s)\n", msg.ID)
+	default:
+		fmt.Printf("Message sent to %s\n", cmd.To)
+	}
 }
 
 func runInject(cmd *Command) {
@@ -248,6 +500,7 @@ func runInject(cmd *Command) {
 		fmt.Fprintf(os.Stderr, "Failed to inject message: %v\n", err)
 		os.Exit(1)
 	}
+	recordEnqueued(cmd, msg)
 
 	fmt.Printf("Injected message as %s to %s\n", cmd.As, cmd.To)
 }
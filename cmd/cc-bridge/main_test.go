@@ -115,3 +115,76 @@ func TestDefaultDataDir(t *testing.T) {
 		t.Errorf("unexpected data dir: %s", dir)
 	}
 }
+
+func TestParseArgs_StartWithRouting(t *testing.T) {
+	args := []string{"start", "--route", "--max-hops", "4"}
+	cmd, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if !cmd.Route {
+		t.Error("expected Route=true")
+	}
+	if cmd.MaxHops != 4 {
+		t.Errorf("expected MaxHops=4, got %d", cmd.MaxHops)
+	}
+}
+
+func TestParseArgs_StartWithMaxConcurrent(t *testing.T) {
+	args := []string{"start", "--max-concurrent", "2"}
+	cmd, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.MaxConcurrent != 2 {
+		t.Errorf("expected MaxConcurrent=2, got %d", cmd.MaxConcurrent)
+	}
+}
+
+func TestParseArgs_StartWatch(t *testing.T) {
+	cmd, err := ParseArgs([]string{"start"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if !cmd.Watch {
+		t.Error("expected Watch to default to true")
+	}
+
+	cmd, err = ParseArgs([]string{"start", "--watch=false"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Watch {
+		t.Error("expected --watch=false to disable watching")
+	}
+}
+
+func TestParseArgs_StartWithLeaseTimeout(t *testing.T) {
+	cmd, err := ParseArgs([]string{"start", "--lease-timeout", "90s"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.LeaseTimeout.Seconds() != 90 {
+		t.Errorf("expected LeaseTimeout=90s, got %v", cmd.LeaseTimeout)
+	}
+}
+
+func TestParseArgs_StartWithStream(t *testing.T) {
+	cmd, err := ParseArgs([]string{"start", "--stream"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if !cmd.Stream {
+		t.Error("expected Stream=true")
+	}
+}
+
+func TestParseArgs_StartWithBatch(t *testing.T) {
+	cmd, err := ParseArgs([]string{"start", "--batch", "5"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Batch != 5 {
+		t.Errorf("expected Batch=5, got %d", cmd.Batch)
+	}
+}
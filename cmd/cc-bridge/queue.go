@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// runQueueCmd administers queued messages. Every subcommand goes through the
+// queue's own locking, so it is safe alongside a running broker: a queued
+// message cannot be leased while a command changes it, and a message already
+// in flight is left alone.
+func runQueueCmd(cmd *Command) {
+	qMgr, err := queue.NewManager(filepath.Join(cmd.DataDir, "queues"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create queue manager: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch cmd.Subcommand {
+	case "ls":
+		runQueueList(cmd, qMgr)
+	case "peek":
+		runQueuePeek(cmd, qMgr)
+	case "show":
+		runQueueShow(cmd, qMgr)
+	case "rm":
+		runQueueRemove(cmd, qMgr)
+	case "purge":
+		runQueuePurge(cmd, qMgr)
+	case "move":
+		runQueueMove(cmd, qMgr)
+	}
+}
+
+func runQueueList(cmd *Command, qMgr *queue.Manager) {
+	agents, err := selectedAgents(cmd, qMgr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list agents: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	found := false
+	for _, agent := range agents {
+		q, err := qMgr.GetQueue(agent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+			os.Exit(1)
+		}
+		leases, err := q.InFlight()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list in-flight messages for %s: %v\n", agent, err)
+			os.Exit(1)
+		}
+		msgs, err := q.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list messages for %s: %v\n", agent, err)
+			os.Exit(1)
+		}
+		if len(leases) == 0 && len(msgs) == 0 {
+			continue
+		}
+
+		found = true
+		fmt.Printf("%s: %d queued, %d in flight\n", agent, len(msgs), len(leases))
+		for _, l := range leases {
+			fmt.Println(formatQueued(l.Message, fmt.Sprintf("in flight %s", now.Sub(l.LeasedAt).Round(time.Second))))
+		}
+		for _, msg := range msgs {
+			state := "due"
+			if msg.NotBefore != nil && msg.NotBefore.After(now) {
+				state = fmt.Sprintf("retry in %s", msg.NotBefore.Sub(now).Round(time.Second))
+			}
+			fmt.Println(formatQueued(msg, state))
+		}
+	}
+
+	if !found {
+		fmt.Println("No queued messages")
+	}
+}
+
+// formatQueued renders a message as one line of queue ls output
+func formatQueued(msg *schema.Message, state string) string {
+	text := strings.Join(strings.Fields(msg.Payload.Text), " ")
+	if runes := []rune(text); len(runes) > 60 {
+		text = string(runes[:57]) + "..."
+	}
+	return fmt.Sprintf("  %s  %s  from %-8s %-14s %q",
+		msg.ID, msg.Timestamp.Local().Format("2006-01-02 15:04:05"), msg.From, state, text)
+}
+
+func runQueuePeek(cmd *Command, qMgr *queue.Manager) {
+	if cmd.Agent == "" {
+		fmt.Fprintf(os.Stderr, "Error: queue peek requires --agent\n")
+		os.Exit(1)
+	}
+
+	q, err := qMgr.GetQueue(cmd.Agent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+		os.Exit(1)
+	}
+	msg, err := q.Peek()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to peek at %s: %v\n", cmd.Agent, err)
+		os.Exit(1)
+	}
+	if msg == nil {
+		fmt.Printf("No messages due for %s\n", cmd.Agent)
+		return
+	}
+
+	data, _ := json.MarshalIndent(msg, "", "  ")
+	fmt.Println(string(data))
+}
+
+func runQueueShow(cmd *Command, qMgr *queue.Manager) {
+	if len(cmd.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: queue show requires a message ID\n")
+		os.Exit(1)
+	}
+
+	_, msg, _, err := findQueued(cmd, qMgr, cmd.Args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, _ := json.MarshalIndent(msg, "", "  ")
+	fmt.Println(string(data))
+}
+
+func runQueueRemove(cmd *Command, qMgr *queue.Manager) {
+	if len(cmd.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: queue rm requires at least one message ID\n")
+		os.Exit(1)
+	}
+
+	for _, id := range cmd.Args {
+		agent, err := findPending(cmd, qMgr, id)
+		if err == nil {
+			var q *queue.Queue
+			if q, err = qMgr.GetQueue(agent); err == nil {
+				err = q.Remove(id)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s from %s\n", id, agent)
+	}
+}
+
+func runQueuePurge(cmd *Command, qMgr *queue.Manager) {
+	if cmd.Agent == "" {
+		fmt.Fprintf(os.Stderr, "Error: queue purge requires --agent\n")
+		os.Exit(1)
+	}
+
+	q, err := qMgr.GetQueue(cmd.Agent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+		os.Exit(1)
+	}
+
+	verb := "Purged"
+	var n int
+	if cmd.Archive {
+		verb = "Archived"
+		archive, err := qMgr.GetArchiveQueue(cmd.Agent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get archive queue: %v\n", err)
+			os.Exit(1)
+		}
+		n, err = q.MoveTo(archive)
+	} else {
+		n, err = q.Purge()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to purge %s after %d message(s): %v\n", cmd.Agent, n, err)
+		os.Exit(1)
+	}
+
+	line := fmt.Sprintf("%s %d message(s) from %s", verb, n, cmd.Agent)
+	if leases, err := q.InFlight(); err == nil && len(leases) > 0 {
+		line += fmt.Sprintf("; %d in flight left alone", len(leases))
+	}
+	fmt.Println(line)
+}
+
+func runQueueMove(cmd *Command, qMgr *queue.Manager) {
+	if len(cmd.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Error: queue move requires a message ID\n")
+		os.Exit(1)
+	}
+	if cmd.To == "" {
+		fmt.Fprintf(os.Stderr, "Error: --to is required\n")
+		os.Exit(1)
+	}
+
+	// Moving to a mistyped name would strand the message in a queue no
+	// broker reads
+	reg, err := openRegistry(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open agent registry: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := reg.Get(cmd.To); err != nil {
+		if errors.Is(err, registry.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Error: unknown agent %q\n", cmd.To)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	id := cmd.Args[0]
+	agent, err := findPending(cmd, qMgr, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if agent == cmd.To {
+		fmt.Fprintf(os.Stderr, "Error: %s is already queued for %s\n", id, agent)
+		os.Exit(1)
+	}
+
+	src, err := qMgr.GetQueue(agent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+		os.Exit(1)
+	}
+	dst, err := qMgr.GetQueue(cmd.To)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get queue: %v\n", err)
+		os.Exit(1)
+	}
+	msg, err := src.Move(id, dst, cmd.To)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to move %s: %v\n", id, err)
+		os.Exit(1)
+	}
+	recordEnqueued(cmd, msg)
+
+	fmt.Printf("Moved %s from %s to %s\n", id, agent, cmd.To)
+}
+
+// findQueued looks up a queued or in-flight message by ID, searching every
+// agent unless --agent narrows it down. It returns the agent, the message and
+// whether it is in flight.
+func findQueued(cmd *Command, qMgr *queue.Manager, id string) (string, *schema.Message, bool, error) {
+	agents, err := selectedAgents(cmd, qMgr)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	for _, agent := range agents {
+		q, err := qMgr.GetQueue(agent)
+		if err != nil {
+			return "", nil, false, err
+		}
+		msg, err := q.Get(id)
+		if err == nil {
+			return agent, msg, false, nil
+		}
+		if !errors.Is(err, queue.ErrNotFound) {
+			return "", nil, false, err
+		}
+
+		leases, err := q.InFlight()
+		if err != nil {
+			return "", nil, false, err
+		}
+		for _, l := range leases {
+			if l.Message.ID == id {
+				return agent, l.Message, true, nil
+			}
+		}
+	}
+	return "", nil, false, fmt.Errorf("message %s not found in %s", id, strings.Join(agents, ", "))
+}
+
+// findPending returns the agent whose queue holds id, failing if the message
+// is already in flight and so can no longer be changed
+func findPending(cmd *Command, qMgr *queue.Manager, id string) (string, error) {
+	agent, _, leased, err := findQueued(cmd, qMgr, id)
+	if err != nil {
+		return "", err
+	}
+	if leased {
+		return "", fmt.Errorf("%s is in flight for %s and can no longer be changed", id, agent)
+	}
+	return agent, nil
+}
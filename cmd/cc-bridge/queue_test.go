@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestParseArgs_QueueMove(t *testing.T) {
+	cmd, err := ParseArgs([]string{"queue", "move", "id-1", "--to", "agent-b"})
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if cmd.Command != "queue" || cmd.Subcommand != "move" {
+		t.Errorf("expected queue move, got %q %q", cmd.Command, cmd.Subcommand)
+	}
+	if len(cmd.Args) != 1 || cmd.Args[0] != "id-1" || cmd.To != "agent-b" {
+		t.Errorf("expected Args=[id-1] To=agent-b, got %v %q", cmd.Args, cmd.To)
+	}
+
+	if _, err := ParseArgs([]string{"queue", "drop"}); err == nil {
+		t.Error("expected error for unknown subcommand")
+	}
+}
+
+func TestFormatQueued_TruncatesRunes(t *testing.T) {
+	msg := schema.NewUserMessage("agent-a", strings.Repeat("é", 70))
+	line := formatQueued(msg, "due")
+	if !strings.Contains(line, `"`+strings.Repeat("é", 57)+`..."`) {
+		t.Errorf("expected 57 whole characters and an ellipsis, got %s", line)
+	}
+}
+
+func TestQueueCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	qMgr, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+	q, _ := qMgr.GetQueue("agent-a")
+
+	leased := schema.NewUserMessage("agent-a", "already answering")
+	q.Enqueue(leased)
+	q.Lease(time.Minute)
+	first := schema.NewUserMessage("agent-a", "first")
+	q.Enqueue(first)
+	second := schema.NewUserMessage("agent-a", "second")
+	q.Enqueue(second)
+	third := schema.NewUserMessage("agent-a", "third")
+	q.Enqueue(third)
+
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := runCLI(append(args, "--data-dir", dataDir)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v failed: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+
+	out := run("queue", "ls")
+	for _, want := range []string{"agent-a: 3 queued, 1 in flight", leased.ID, "in flight", first.ID, `"second"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in queue ls output:\n%s", want, out)
+		}
+	}
+
+	var msg schema.Message
+	if err := json.Unmarshal([]byte(run("queue", "peek", "--agent", "agent-a")), &msg); err != nil || msg.ID != first.ID {
+		t.Errorf("expected peek to show %s, got %+v, %v", first.ID, msg, err)
+	}
+	if err := json.Unmarshal([]byte(run("queue", "show", second.ID)), &msg); err != nil || msg.Payload.Text != "second" {
+		t.Errorf("expected show to print %s, got %+v, %v", second.ID, msg, err)
+	}
+
+	if out := run("queue", "rm", first.ID); !strings.Contains(out, "Removed "+first.ID+" from agent-a") {
+		t.Errorf("unexpected rm output:\n%s", out)
+	}
+	if out, err := runCLI("queue", "rm", leased.ID, "--data-dir", dataDir).CombinedOutput(); err == nil || !strings.Contains(string(out), "in flight") {
+		t.Errorf("expected rm of an in-flight message to fail, got %v:\n%s", err, out)
+	}
+
+	if out, err := runCLI("queue", "move", second.ID, "--to", "nobody", "--data-dir", dataDir).CombinedOutput(); err == nil {
+		t.Errorf("expected move to an unknown agent to fail:\n%s", out)
+	}
+	run("queue", "move", second.ID, "--to", "agent-b")
+	b, _ := qMgr.GetQueue("agent-b")
+	if moved, err := b.Get(second.ID); err != nil || moved.To != "agent-b" {
+		t.Errorf("expected %s readdressed in agent-b's queue, got %+v, %v", second.ID, moved, err)
+	}
+
+	if out := run("queue", "purge", "--agent", "agent-a", "--archive"); !strings.Contains(out, "Archived 1 message(s) from agent-a; 1 in flight left alone") {
+		t.Errorf("unexpected purge output:\n%s", out)
+	}
+	archive, _ := qMgr.GetArchiveQueue("agent-a")
+	if n, _ := archive.Len(); n != 1 {
+		t.Errorf("expected 1 archived message, got %d", n)
+	}
+	if out := run("queue", "purge", "--agent", "agent-b"); !strings.Contains(out, "Purged 1 message(s) from agent-b") {
+		t.Errorf("unexpected purge output:\n%s", out)
+	}
+	if n, _ := b.Len(); n != 0 {
+		t.Errorf("expected agent-b's queue empty, got %d", n)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// Outcomes of send, as reported by --json
+const (
+	statusSent    = "sent"
+	statusReplied = "replied"
+	statusFailed  = "failed"
+	statusTimeout = "timeout"
+)
+
+// Exit status of send --wait when the message is dead-lettered or no reply
+// arrives in time
+const (
+	exitFailed  = 1
+	exitTimeout = 2
+)
+
+// sendResult is what send prints with --json
+type sendResult struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Reply  *schema.Message `json:"reply,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// waitForReply follows the history from cursor until the message with the
+// given ID is answered or dead-lettered. A zero timeout waits indefinitely.
+func waitForReply(cursor *history.Cursor, id string, timeout time.Duration) (*sendResult, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(historyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		records, err := cursor.Next()
+		if err != nil {
+			var bad *history.MalformedError
+			if !errors.As(err, &bad) {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		for _, r := range records {
+			if result := settles(id, r); result != nil {
+				return result, nil
+			}
+		}
+
+		select {
+		case <-deadline:
+			return &sendResult{ID: id, Status: statusTimeout, Error: fmt.Sprintf("no reply within %s", timeout)}, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// settles returns the outcome r records for the message with the given ID,
+// or nil if r is about something else. Batched replies list every message
+// they answer in batch_ids.
+func settles(id string, r *history.Record) *sendResult {
+	msg := r.Message
+	if msg == nil {
+		return nil
+	}
+
+	switch r.Event {
+	case history.EventResponse:
+		batch := strings.Split(msg.Payload.Metadata["batch_ids"], ",")
+		if msg.InReplyTo == id || slices.Contains(batch, id) {
+			return &sendResult{ID: id, Status: statusReplied, Reply: msg}
+		}
+	case history.EventDeadLettered:
+		if msg.ID == id {
+			return &sendResult{ID: id, Status: statusFailed, Error: msg.Payload.Metadata["error"]}
+		}
+	}
+	return nil
+}
+
+// printSendResult prints the reply, or the failure on stderr, and exits
+// non-zero unless the message was sent or answered
+func printSendResult(cmd *Command, result *sendResult) {
+	if cmd.JSON {
+		data, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode result: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	}
+
+	switch result.Status {
+	case statusReplied:
+		if !cmd.JSON {
+			fmt.Println(result.Reply.Payload.Text)
+		}
+	case statusFailed:
+		if !cmd.JSON {
+			fmt.Fprintf(os.Stderr, "Error: message %s failed: %s\n", result.ID, result.Error)
+		}
+		os.Exit(exitFailed)
+	case statusTimeout:
+		if !cmd.JSON {
+			fmt.Fprintf(os.Stderr, "Error: %s for message %s\n", result.Error, result.ID)
+		}
+		os.Exit(exitTimeout)
+	}
+}
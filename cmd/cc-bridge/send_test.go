@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestSettles(t *testing.T) {
+	reply := schema.NewAgentMessage(schema.AgentA, schema.Human, "done")
+	reply.InReplyTo = "msg-2"
+	reply.WithMetadata("batch_ids", "msg-1,msg-2")
+
+	for _, id := range []string{"msg-1", "msg-2"} {
+		if r := settles(id, &history.Record{Event: history.EventResponse, Message: reply}); r == nil || r.Status != statusReplied {
+			t.Errorf("expected %s answered, got %+v", id, r)
+		}
+	}
+	if r := settles("msg-3", &history.Record{Event: history.EventResponse, Message: reply}); r != nil {
+		t.Errorf("expected an unrelated reply ignored, got %+v", r)
+	}
+
+	dead := schema.NewUserMessage(schema.AgentA, "hello")
+	dead.WithMetadata("error", "claude exploded")
+	r := settles(dead.ID, &history.Record{Event: history.EventDeadLettered, Message: dead})
+	if r == nil || r.Status != statusFailed || r.Error != "claude exploded" {
+		t.Errorf("expected failure, got %+v", r)
+	}
+	if r := settles(dead.ID, &history.Record{Event: history.EventProcessed, Message: dead}); r != nil {
+		t.Errorf("expected processing not to settle the message, got %+v", r)
+	}
+}
+
+// startSend runs send --wait in the background and returns the message it
+// queued once it appears
+func startSend(t *testing.T, dataDir string, args ...string) (*exec.Cmd, *bytes.Buffer, *bytes.Buffer, *schema.Message) {
+	t.Helper()
+	cmd := runCLI(append([]string{"send", "--to", "agent-a", "--wait", "--data-dir", dataDir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start send: %v", err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	qMgr, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+	q, _ := qMgr.GetQueue("agent-a")
+	var msg *schema.Message
+	waitFor(t, "message queued", func() bool {
+		msgs, _ := q.List()
+		if len(msgs) == 0 {
+			return false
+		}
+		msg = msgs[0]
+		return true
+	})
+	return cmd, &stdout, &stderr, msg
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func TestSendWait_Reply(t *testing.T) {
+	dataDir := t.TempDir()
+	cmd, stdout, stderr, msg := startSend(t, dataDir, "--json", "what is the code word?")
+
+	hist, _ := history.NewStore(filepath.Join(dataDir, "history"))
+	unrelated := schema.NewAgentMessage(schema.AgentA, schema.Human, "not this one")
+	unrelated.InReplyTo = "some-other-message"
+	hist.Append(history.EventResponse, unrelated)
+
+	reply := schema.NewAgentMessage(schema.AgentA, schema.Human, "DELTA-7")
+	reply.InReplyTo = msg.ID
+	hist.Append(history.EventResponse, reply)
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("send --wait failed: %v\n%s", err, stderr)
+	}
+	var result sendResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("expected JSON output, got %q: %v", stdout, err)
+	}
+	if result.ID != msg.ID || result.Status != statusReplied || result.Reply.Payload.Text != "DELTA-7" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestSendWait_DeadLettered(t *testing.T) {
+	dataDir := t.TempDir()
+	cmd, stdout, stderr, msg := startSend(t, dataDir, "hello")
+
+	hist, _ := history.NewStore(filepath.Join(dataDir, "history"))
+	msg.WithMetadata("error", "attempts exhausted")
+	hist.Append(history.EventDeadLettered, msg)
+
+	if code := exitCode(cmd.Wait()); code != exitFailed {
+		t.Errorf("expected exit status %d, got %d", exitFailed, code)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "attempts exhausted") {
+		t.Errorf("expected the error on stderr only, got stdout %q, stderr %q", stdout, stderr)
+	}
+}
+
+func TestSendWait_Timeout(t *testing.T) {
+	dataDir := t.TempDir()
+	out, err := runCLI("send", "--to", "agent-a", "--wait", "--timeout", "200ms", "--json", "--data-dir", dataDir, "hello").Output()
+	if code := exitCode(err); code != exitTimeout {
+		t.Errorf("expected exit status %d, got %d", exitTimeout, code)
+	}
+	var result sendResult
+	if err := json.Unmarshal(out, &result); err != nil || result.Status != statusTimeout {
+		t.Errorf("expected a timeout result, got %q", out)
+	}
+
+	if err := runCLI("send", "--to", "agent-a", "--timeout", "1s", "--data-dir", dataDir, "hello").Run(); err == nil {
+		t.Error("expected --timeout without --wait to be rejected")
+	}
+}
+
+// TestSendWait_Broker runs a real broker with an agent backed by a shell
+// command and uses send --wait as a request/response call
+func TestSendWait_Broker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping broker process test in short mode")
+	}
+	dataDir := t.TempDir()
+	runCLI("agent", "set", "agent-a", "--command", `sh -c "echo you said: $0" {{message}}`, "--data-dir", dataDir).Run()
+
+	broker := runCLI("start", "--poll-interval", "100ms", "--data-dir", dataDir)
+	if err := broker.Start(); err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer func() {
+		broker.Process.Signal(syscall.SIGTERM)
+		broker.Wait()
+	}()
+
+	out, err := runCLI("send", "--to", "agent-a", "--wait", "--timeout", "10s", "--data-dir", dataDir, "ping").CombinedOutput()
+	if err != nil {
+		t.Fatalf("send --wait failed: %v\n%s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != "you said: ping" {
+		t.Errorf("expected only the reply on stdout, got %q", out)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
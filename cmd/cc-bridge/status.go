@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/broker"
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// statusReport is everything status shows, and what --json prints
+type statusReport struct {
+	Broker brokerStatus   `json:"broker"`
+	Run    *cost.Run      `json:"run,omitempty"`
+	Inbox  int            `json:"inbox"` // Messages waiting for the human
+	Agents []*agentStatus `json:"agents"`
+}
+
+type brokerStatus struct {
+	Running       bool       `json:"running"`
+	PID           int        `json:"pid,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+}
+
+type agentStatus struct {
+	ID           string         `json:"id"`
+	State        string         `json:"state,omitempty"` // Empty if the agent is no longer registered
+	Queued       int            `json:"queued"`
+	OldestQueued *time.Time     `json:"oldest_queued,omitempty"`
+	DeadLetters  int            `json:"dead_letters"`
+	InFlight     []inFlightTurn `json:"in_flight,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
+	Turn         int            `json:"turn"`
+	LastActivity *time.Time     `json:"last_activity,omitempty"`
+	ForkedFrom   string         `json:"forked_from,omitempty"`
+	Spend        cost.Spend     `json:"spend"`
+	Budget       float64        `json:"budget,omitempty"`
+	Paused       bool           `json:"paused,omitempty"`
+}
+
+// inFlightTurn is a message an agent is working on
+type inFlightTurn struct {
+	MessageID string    `json:"message_id"`
+	From      string    `json:"from"`
+	StartedAt time.Time `json:"started_at"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+func runStatus(cmd *Command) {
+	report, err := buildStatus(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read status: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cmd.JSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to marshal status: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	printStatus(report, time.Now())
+}
+
+// buildStatus gathers the state of the data directory. Everything it reads
+// is written through by a running broker, so it is current without asking
+// the broker.
+func buildStatus(cmd *Command) (*statusReport, error) {
+	// status only reads: it neither seeds the registry nor creates any of the
+	// broker's directories, so a mistyped --data-dir is reported, not set up
+	if _, err := os.Stat(cmd.DataDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no cc-bridge data in %s", cmd.DataDir)
+		}
+		return nil, err
+	}
+	report := &statusReport{Agents: []*agentStatus{}}
+
+	h, err := broker.ReadHeartbeat(filepath.Join(cmd.DataDir, "broker.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if h != nil {
+		report.Broker = brokerStatus{
+			Running:       h.Alive(time.Now()),
+			PID:           h.PID,
+			StartedAt:     &h.StartedAt,
+			LastHeartbeat: &h.UpdatedAt,
+		}
+	}
+
+	agents := make(map[string]*agentStatus)
+	get := func(id string) *agentStatus {
+		a, ok := agents[id]
+		if !ok {
+			a = &agentStatus{ID: id}
+			agents[id] = a
+		}
+		return a
+	}
+
+	reg, err := registry.NewRegistry(cmd.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open agent registry: %w", err)
+	}
+	registered, err := reg.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	for _, r := range registered {
+		a := get(r.ID)
+		a.State = r.State
+		a.Budget = r.Profile.Budget
+	}
+
+	if sessionDir := filepath.Join(cmd.DataDir, "sessions"); exists(sessionDir) {
+		sMgr, err := session.NewManager(sessionDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session manager: %w", err)
+		}
+		if err := sMgr.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load sessions: %w", err)
+		}
+		for _, s := range sMgr.ListSessions() {
+			a := get(s.AgentID)
+			a.SessionID = s.SessionID
+			a.Turn = s.TurnNumber
+			a.ForkedFrom = s.ForkedFrom
+			if s.SessionID != "" {
+				a.LastActivity = &s.UpdatedAt
+			}
+		}
+	}
+
+	ledger, err := cost.NewLedger(cmd.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := ledger.Read()
+	if err != nil {
+		return nil, err
+	}
+	report.Run = totals.Run
+	for id := range totals.Agents {
+		get(id)
+	}
+
+	qMgr := queue.OpenManager(filepath.Join(cmd.DataDir, "queues"))
+	queues, err := qMgr.ListAgents()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range queues {
+		switch id {
+		case schema.Human:
+			q, err := qMgr.GetQueue(id)
+			if err != nil {
+				return nil, err
+			}
+			if report.Inbox, err = q.Len(); err != nil {
+				return nil, fmt.Errorf("failed to read inbox: %w", err)
+			}
+		case schema.Broadcast:
+		default:
+			get(id)
+		}
+	}
+
+	for _, a := range agents {
+		if err := readQueues(qMgr, a); err != nil {
+			return nil, fmt.Errorf("failed to read queues of %s: %w", a.ID, err)
+		}
+
+		a.Spend = totals.Agent(a.ID)
+		if a.Budget == 0 && totals.Run != nil {
+			a.Budget = totals.Run.AgentBudget
+		}
+		a.Paused = a.Budget > 0 && a.Spend.USD >= a.Budget
+		report.Agents = append(report.Agents, a)
+	}
+	slices.SortFunc(report.Agents, func(x, y *agentStatus) int { return strings.Compare(x.ID, y.ID) })
+	return report, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readQueues fills in what is waiting in, leased from and dead-lettered by
+// an agent's queue
+func readQueues(qMgr *queue.Manager, a *agentStatus) error {
+	q, err := qMgr.GetQueue(a.ID)
+	if err != nil {
+		return err
+	}
+	if a.Queued, err = q.Len(); err != nil {
+		return err
+	}
+	if a.Queued > 0 {
+		msgs, err := q.List()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if a.OldestQueued == nil || msg.Timestamp.Before(*a.OldestQueued) {
+				a.OldestQueued = &msg.Timestamp
+			}
+		}
+	}
+
+	leases, err := q.InFlight()
+	if err != nil {
+		return err
+	}
+	for _, l := range leases {
+		a.InFlight = append(a.InFlight, inFlightTurn{
+			MessageID: l.Message.ID,
+			From:      l.Message.From,
+			StartedAt: l.LeasedAt,
+			Deadline:  l.Deadline,
+		})
+	}
+
+	dlq, err := qMgr.GetDeadLetterQueue(a.ID)
+	if err != nil {
+		return err
+	}
+	a.DeadLetters, err = dlq.Len()
+	return err
+}
+
+func printStatus(report *statusReport, now time.Time) {
+	ago := func(t time.Time) string { return now.Sub(t).Round(time.Second).String() }
+
+	switch b := report.Broker; {
+	case b.Running:
+		fmt.Printf("Broker: running (pid %d, up %s)\n", b.PID, ago(*b.StartedAt))
+	case b.LastHeartbeat != nil:
+		fmt.Printf("Broker: not responding (pid %d, last heartbeat %s ago)\n", b.PID, ago(*b.LastHeartbeat))
+	default:
+		fmt.Println("Broker: not running")
+	}
+	if run := report.Run; run != nil {
+		fmt.Printf("This run: %s, %d turn(s), started %s\n",
+			spendSummary(run.Spend.USD, run.Budget), run.Spend.Turns, run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if report.Inbox > 0 {
+		fmt.Printf("Inbox: %d message(s) for %s\n", report.Inbox, schema.Human)
+	}
+
+	if len(report.Agents) == 0 {
+		fmt.Println("No agents")
+		return
+	}
+	fmt.Println("Agents:")
+	for _, a := range report.Agents {
+		header := a.ID
+		if a.State != "" {
+			header += " (" + a.State + ")"
+		} else {
+			header += " (not registered)"
+		}
+		fmt.Printf("  %s\n", header)
+
+		queued := fmt.Sprintf("%d pending", a.Queued)
+		if a.OldestQueued != nil {
+			queued += fmt.Sprintf(", oldest %s ago", ago(*a.OldestQueued))
+		}
+		if a.DeadLetters > 0 {
+			queued += fmt.Sprintf(", %d dead-lettered", a.DeadLetters)
+		}
+		fmt.Printf("    queue: %s\n", queued)
+
+		for _, t := range a.InFlight {
+			fmt.Printf("    in flight: %s from %s, running %s\n", t.MessageID, t.From, ago(t.StartedAt))
+		}
+
+		conversation := "not started"
+		if a.SessionID != "" {
+			conversation = fmt.Sprintf("turn %d, session %s, last activity %s ago", a.Turn, a.SessionID, ago(*a.LastActivity))
+		}
+		if a.ForkedFrom != "" {
+			conversation += ", forked from " + a.ForkedFrom
+		}
+		fmt.Printf("    session: %s\n", conversation)
+
+		spend := fmt.Sprintf("%s, %d turn(s)", spendSummary(a.Spend.USD, a.Budget), a.Spend.Turns)
+		if a.Paused {
+			spend += " (paused)"
+		}
+		fmt.Printf("    cost: %s\n", spend)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/broker"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestStatus(t *testing.T) {
+	dataDir := t.TempDir()
+	reg, _ := registry.NewRegistry(dataDir)
+	reg.Seed(registry.Profile{}, "agent-a", "agent-b")
+	qMgr, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+
+	q, _ := qMgr.GetQueue("agent-a")
+	working := schema.NewUserMessage("agent-a", "working")
+	q.Enqueue(working)
+	if _, err := q.Lease(time.Minute); err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	old := schema.NewUserMessage("agent-a", "old")
+	old.Timestamp = time.Now().Add(-time.Hour)
+	q.Enqueue(old)
+	q.Enqueue(schema.NewUserMessage("agent-a", "new"))
+	dlq, _ := qMgr.GetDeadLetterQueue("agent-a")
+	dlq.Enqueue(schema.NewUserMessage("agent-a", "dead"))
+	inbox, _ := qMgr.GetQueue(schema.Human)
+	inbox.Enqueue(schema.NewMessage("agent-b", schema.Human, schema.TypeMessage, "hi"))
+
+	out, err := runCLI("status", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("status failed: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"Broker: not running",
+		"Inbox: 1 message(s) for human",
+		"agent-a (active)",
+		"queue: 2 pending, oldest 1h0m0s ago, 1 dead-lettered",
+		"in flight: " + working.ID + " from human, running",
+		"agent-b (active)\n    queue: 0 pending\n    session: not started",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in status output:\n%s", want, out)
+		}
+	}
+
+	// A heartbeat the broker stopped refreshing shows it died
+	h := broker.Heartbeat{PID: 4242, StartedAt: time.Now().Add(-time.Hour), UpdatedAt: time.Now().Add(-time.Minute), Interval: broker.HeartbeatInterval}
+	data, _ := json.Marshal(h)
+	os.WriteFile(filepath.Join(dataDir, "broker.json"), data, 0644)
+	out, _ = runCLI("status", "--data-dir", dataDir).CombinedOutput()
+	if !strings.Contains(string(out), "Broker: not responding (pid 4242, last heartbeat 1m0s ago)") {
+		t.Errorf("expected a stale broker in status output:\n%s", out)
+	}
+
+	h.UpdatedAt = time.Now()
+	data, _ = json.Marshal(h)
+	os.WriteFile(filepath.Join(dataDir, "broker.json"), data, 0644)
+	out, err = runCLI("status", "--json", "--data-dir", dataDir).Output()
+	if err != nil {
+		t.Fatalf("status --json failed: %v", err)
+	}
+	var report statusReport
+	if err := json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("expected JSON output: %v\n%s", err, out)
+	}
+	if !report.Broker.Running || report.Broker.PID != 4242 || report.Inbox != 1 {
+		t.Errorf("unexpected broker or inbox status: %+v", report)
+	}
+	if len(report.Agents) != 2 || report.Agents[0].ID != "agent-a" {
+		t.Fatalf("expected agent-a and agent-b, got %+v", report.Agents)
+	}
+	a := report.Agents[0]
+	if a.Queued != 2 || a.DeadLetters != 1 || a.OldestQueued == nil || !a.OldestQueued.Equal(old.Timestamp) {
+		t.Errorf("unexpected queue status: %+v", a)
+	}
+	if len(a.InFlight) != 1 || a.InFlight[0].MessageID != working.ID || time.Since(a.InFlight[0].StartedAt) > time.Minute {
+		t.Errorf("expected %s in flight, got %+v", working.ID, a.InFlight)
+	}
+}
+
+func TestStatusCreatesNothing(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "typo")
+	if out, err := runCLI("status", "--data-dir", missing).CombinedOutput(); err == nil || !strings.Contains(string(out), "no cc-bridge data in") {
+		t.Errorf("expected status of a missing data dir to fail, got %v:\n%s", err, out)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected the data dir not to be created, got %v", err)
+	}
+
+	// An empty data dir is reported as it is, without seeding agents
+	empty := t.TempDir()
+	out, err := runCLI("status", "--data-dir", empty).CombinedOutput()
+	if err != nil {
+		t.Fatalf("status failed: %v\n%s", err, out)
+	}
+	if entries, _ := os.ReadDir(empty); len(entries) != 0 {
+		t.Errorf("expected status to leave the data dir empty, found %d entries", len(entries))
+	}
+}
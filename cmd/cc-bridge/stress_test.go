@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+)
+
+// TestMain lets tests run the real CLI in a child process by re-executing
+// the test binary with CC_BRIDGE_RUN_MAIN set
+func TestMain(m *testing.M) {
+	if os.Getenv("CC_BRIDGE_RUN_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs cc-bridge with args in a separate process
+func runCLI(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CC_BRIDGE_RUN_MAIN=1")
+	return cmd
+}
+
+// TestStress_ConcurrentSends runs many send processes while consumers lease
+// from the same queue, checking nothing is lost, duplicated or half-read
+func TestStress_ConcurrentSends(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping multi-process stress test in short mode")
+	}
+
+	dataDir := t.TempDir()
+	const senders = 40
+
+	qMgr, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+	q, _ := qMgr.GetQueue("agent-a")
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	stop := make(chan struct{})
+	var consumers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			// A separate manager per consumer mimics separate broker handles
+			m, _ := queue.NewManager(filepath.Join(dataDir, "queues"))
+			cq, _ := m.GetQueue("agent-a")
+			for {
+				lease, err := cq.Lease(time.Minute)
+				if err != nil {
+					t.Errorf("Lease failed: %v", err)
+					return
+				}
+				if lease == nil {
+					select {
+					case <-stop:
+						return
+					case <-time.After(time.Millisecond):
+						continue
+					}
+				}
+				mu.Lock()
+				received[lease.Message.Payload.Text]++
+				mu.Unlock()
+				cq.Ack(lease)
+			}
+		}()
+	}
+
+	var senderWg sync.WaitGroup
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		senderWg.Add(1)
+		go func(i int) {
+			defer senderWg.Done()
+			cmd := runCLI("send", "--data-dir", dataDir, "--to", "agent-a", fmt.Sprintf("msg-%d", i))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("send %d failed: %v: %s", i, err, out)
+			}
+		}(i)
+	}
+	senderWg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Let consumers drain what is left, then stop them
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if n, _ := q.Len(); n == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	consumers.Wait()
+
+	for i := 0; i < senders; i++ {
+		text := fmt.Sprintf("msg-%d", i)
+		if received[text] != 1 {
+			t.Errorf("%s delivered %d times", text, received[text])
+		}
+	}
+}
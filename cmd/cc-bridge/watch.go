@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+)
+
+// runWatch follows the history of a broker's data dir and prints matching
+// records as they are appended: enqueues, turn starts, replies and failures.
+// With --since it first prints what happened since then.
+func runWatch(cmd *Command) {
+	since, err := ParseTimeArg(cmd.Since, time.Now().UTC())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --since: %v\n", err)
+		os.Exit(1)
+	}
+
+	hist, err := history.NewStore(filepath.Join(cmd.DataDir, "history"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+		os.Exit(1)
+	}
+
+	cursor := hist.CursorAtStart()
+	if since.IsZero() {
+		if cursor, err = hist.Cursor(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	filter := history.Filter{
+		Agent: cmd.Agent,
+		Peer:  cmd.Peer,
+		From:  cmd.From,
+		Type:  cmd.Type,
+		Since: since,
+	}
+	for {
+		records, err := cursor.Next()
+		if err != nil {
+			var bad *history.MalformedError
+			if !errors.As(err, &bad) {
+				fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		for _, r := range records {
+			if filter.Match(r) {
+				printWatched(cmd, r)
+			}
+		}
+		time.Sleep(historyPollInterval)
+	}
+}
+
+func printWatched(cmd *Command, r *history.Record) {
+	if !cmd.JSON {
+		fmt.Println(formatRecord(r))
+		return
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode record: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// startWatch runs watch in the background and returns a channel of the lines
+// it prints
+func startWatch(t *testing.T, args ...string) <-chan string {
+	t.Helper()
+	cmd := runCLI(append([]string{"watch"}, args...)...)
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start watch: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return lines
+}
+
+func nextLine(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watch output")
+		return ""
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dataDir := t.TempDir()
+	hist, _ := history.NewStore(filepath.Join(dataDir, "history"))
+	hist.Append(history.EventResponse, schema.NewAgentMessage(schema.AgentA, schema.Human, "before watch"))
+
+	lines := startWatch(t, "--from", schema.AgentA, "--data-dir", dataDir)
+
+	// Records written before watch started are skipped; once a marker shows
+	// up, watch is following the end of the history
+	for ready := false; !ready; {
+		hist.Append(history.EventResponse, schema.NewAgentMessage(schema.AgentA, schema.Human, "marker"))
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, "marker") {
+				t.Fatalf("expected only new records, got %q", line)
+			}
+			ready = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case <-lines:
+		case <-time.After(300 * time.Millisecond):
+			drained = true
+		}
+	}
+
+	hist.Append(history.EventEnqueued, schema.NewUserMessage(schema.AgentA, "from human"))
+	failed := schema.NewAgentMessage(schema.AgentA, schema.AgentB, "over to you")
+	failed.WithMetadata("error", "claude exploded")
+	hist.Append(history.EventFailed, failed)
+	hist.Append(history.EventResponse, schema.NewAgentMessage(schema.AgentA, schema.Human, "all done"))
+
+	line := nextLine(t, lines)
+	if !strings.Contains(line, "failed") || !strings.Contains(line, "agent-a -> agent-b (message): over to you [error: claude exploded]") {
+		t.Errorf("unexpected first line: %q", line)
+	}
+	if line := nextLine(t, lines); !strings.Contains(line, "response") || !strings.Contains(line, "all done") {
+		t.Errorf("unexpected second line: %q", line)
+	}
+}
+
+func TestWatch_JSONSince(t *testing.T) {
+	dataDir := t.TempDir()
+	hist, _ := history.NewStore(filepath.Join(dataDir, "history"))
+	hist.Append(history.EventEnqueued, schema.NewMessage(schema.AgentB, schema.AgentA, schema.TypeInject, "earlier"))
+	hist.Append(history.EventEnqueued, schema.NewUserMessage(schema.AgentA, "not an injection"))
+
+	// --since replays matching records before following new ones
+	lines := startWatch(t, "--since", "1h", "--type", schema.TypeInject, "--json", "--data-dir", dataDir)
+
+	var r history.Record
+	if err := json.Unmarshal([]byte(nextLine(t, lines)), &r); err != nil {
+		t.Fatalf("expected a JSON record: %v", err)
+	}
+	if r.Event != history.EventEnqueued || r.Message.Payload.Text != "earlier" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+
+	hist.Append(history.EventStarted, schema.NewMessage(schema.AgentB, schema.AgentA, schema.TypeInject, "later"))
+	if err := json.Unmarshal([]byte(nextLine(t, lines)), &r); err != nil || r.Event != history.EventStarted {
+		t.Errorf("expected the new record, got %+v, %v", r, err)
+	}
+}
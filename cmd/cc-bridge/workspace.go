@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/session"
+	"github.com/binaryphile/cc-bridge/internal/workspace"
+)
+
+func openWorkspaces(cmd *Command) (*workspace.Manager, error) {
+	return workspace.NewManager(filepath.Join(cmd.DataDir, "workspaces"))
+}
+
+// ensureWorkspace creates the agent's workspace if its profile asks for one.
+// It runs before the profile is saved, so a running broker never starts the
+// agent in a directory that does not exist yet.
+func ensureWorkspace(cmd *Command, id string, p registry.Profile) error {
+	if p.Workspace == nil {
+		return nil
+	}
+	wsMgr, err := openWorkspaces(cmd)
+	if err != nil {
+		return err
+	}
+	ws, err := wsMgr.Ensure(id, p.Workspace.Repo)
+	if err != nil {
+		return fmt.Errorf("failed to create workspace for %s: %w", id, err)
+	}
+	fmt.Printf("Workspace for %s: %s\n", id, ws.Path)
+	return nil
+}
+
+// ensureWorkspaces creates the workspaces registered agents are missing,
+// such as those of the default agents. It tries every agent, so one that
+// fails does not keep the others from getting theirs.
+func ensureWorkspaces(cmd *Command, reg *registry.Registry) error {
+	agents, err := reg.List()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, a := range agents {
+		if err := ensureWorkspace(cmd, a.ID, a.Profile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// defaultWorkspace gives a new agent a workspace of its own unless the
+// command line says where it runs. Endpoint agents have no working directory.
+func defaultWorkspace(cmd *Command, p registry.Profile) registry.Profile {
+	chosen := cmd.SetFlags["work-dir"] || cmd.SetFlags["workspace"] || cmd.SetFlags["workspace-repo"]
+	if !chosen && p.Endpoint == "" {
+		p.Workspace = &registry.Workspace{}
+	}
+	return p
+}
+
+// agentDir returns the absolute directory the agent's turns run in. Empty
+// means the broker's own working directory.
+func agentDir(cmd *Command, id string, p registry.Profile) string {
+	if p.Workspace == nil {
+		return p.WorkDir
+	}
+	dir := filepath.Join(cmd.DataDir, "workspaces", id)
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return dir
+}
+
+// checkMove refuses to move a claude agent with a conversation to another
+// directory. claude keeps sessions per working directory, so --resume would
+// not find the conversation from the new one.
+func checkMove(cmd *Command, id string, from, to registry.Profile) error {
+	if to.Command != nil || to.Endpoint != "" || agentDir(cmd, id, from) == agentDir(cmd, id, to) {
+		return nil
+	}
+	sMgr, err := session.NewManager(filepath.Join(cmd.DataDir, "sessions"))
+	if err != nil {
+		return err
+	}
+	if err := sMgr.Load(); err != nil {
+		return err
+	}
+	if sess, err := sMgr.GetSession(id); err == nil && sess.SessionID != "" {
+		return fmt.Errorf("%s has a conversation in %s that claude cannot resume from another directory; add a new agent instead",
+			id, displayDir(agentDir(cmd, id, from)))
+	}
+	return nil
+}
+
+// displayDir names a directory from agentDir for messages
+func displayDir(dir string) string {
+	if dir == "" {
+		return "the broker's working directory"
+	}
+	return dir
+}
+
+func runWorkspace(cmd *Command) {
+	wsMgr, err := openWorkspaces(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open workspaces: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch cmd.Subcommand {
+	case "diff":
+		runWorkspaceDiff(cmd, wsMgr)
+	}
+}
+
+// runWorkspaceDiff shows what the agent, or every agent, changed in its
+// workspace
+func runWorkspaceDiff(cmd *Command, wsMgr *workspace.Manager) {
+	if cmd.Agent != "" {
+		diff, err := wsMgr.Diff(cmd.Agent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(diff)
+		return
+	}
+
+	workspaces, err := wsMgr.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(workspaces) == 0 {
+		fmt.Println("No workspaces")
+		return
+	}
+	for i, ws := range workspaces {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("==> %s (%s) <==\n", ws.Agent, ws.Path)
+		diff, err := wsMgr.Diff(ws.Agent)
+		if errors.Is(err, workspace.ErrNoBase) {
+			fmt.Println("Plain directory, nothing to diff")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(diff) == 0 {
+			fmt.Println("No changes")
+			continue
+		}
+		os.Stdout.Write(diff)
+	}
+}
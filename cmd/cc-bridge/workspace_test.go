@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binaryphile/cc-bridge/internal/broker"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/session"
+	"github.com/binaryphile/cc-bridge/internal/workspace"
+)
+
+func TestWorkspaceCommands(t *testing.T) {
+	dataDir := t.TempDir()
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "main.go"), []byte("package main\n"), 0644)
+	exec.Command("git", "-C", repo, "init", "--quiet").Run()
+	exec.Command("git", "-C", repo, "add", ".").Run()
+	exec.Command("git", "-C", repo, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "-m", "init").Run()
+
+	out, err := runCLI("agent", "add", "agent-c", "--workspace-repo", repo, "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent add failed: %v\n%s", err, out)
+	}
+	path := filepath.Join(dataDir, "workspaces", "agent-c")
+	if !strings.Contains(string(out), "Workspace for agent-c: "+path) {
+		t.Errorf("expected the workspace path reported:\n%s", out)
+	}
+
+	reg, _ := registry.NewRegistry(dataDir)
+	a, _ := reg.Get("agent-c")
+	if a.Profile.Workspace == nil || a.Profile.Workspace.Repo != repo {
+		t.Errorf("expected workspace in profile, got %+v", a.Profile.Workspace)
+	}
+
+	if out, err := runCLI("agent", "set", "agent-b", "--workspace", "--data-dir", dataDir).CombinedOutput(); err != nil {
+		t.Fatalf("agent set failed: %v\n%s", err, out)
+	}
+	os.WriteFile(filepath.Join(path, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+
+	out, err = runCLI("workspace", "diff", "--agent", "agent-c", "--data-dir", dataDir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("workspace diff failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "+func main() {}") {
+		t.Errorf("expected agent-c's edit in the diff:\n%s", out)
+	}
+
+	out, _ = runCLI("workspace", "diff", "--data-dir", dataDir).CombinedOutput()
+	for _, want := range []string{"==> agent-b (", "Plain directory, nothing to diff", "==> agent-c (", "+func main() {}"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in the diff of all workspaces:\n%s", want, out)
+		}
+	}
+
+	// New agents get a workspace unless told where to run
+	runCLI("agent", "add", "agent-d", "--data-dir", dataDir).Run()
+	runCLI("agent", "add", "agent-e", "--workspace=false", "--data-dir", dataDir).Run()
+	if d, _ := reg.Get("agent-d"); d == nil || d.Profile.Workspace == nil {
+		t.Errorf("expected agent-d to get a workspace by default, got %+v", d)
+	}
+	if e, _ := reg.Get("agent-e"); e == nil || e.Profile.Workspace != nil {
+		t.Errorf("expected agent-e to opt out of a workspace, got %+v", e)
+	}
+
+	// An agent with a conversation cannot move away from it
+	sMgr, _ := session.NewManager(filepath.Join(dataDir, "sessions"))
+	sMgr.CreateSession("agent-e")
+	sMgr.SetSessionID("agent-e", "session-1")
+	out, err = runCLI("agent", "set", "agent-e", "--workspace", "--data-dir", dataDir).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "cannot resume") {
+		t.Errorf("expected moving agent-e to fail, got %v:\n%s", err, out)
+	}
+
+	// A workspace replaces the work dir, so the two cannot be combined
+	if out, err := runCLI("agent", "set", "agent-c", "--work-dir", repo, "--data-dir", dataDir).CombinedOutput(); err == nil {
+		t.Errorf("expected --work-dir with a workspace to fail:\n%s", out)
+	}
+}
+
+func TestExecutorFactoryUsesWorkspace(t *testing.T) {
+	wsMgr, _ := workspace.NewManager(t.TempDir())
+	factory := executorFactory(&Command{}, nil, wsMgr)
+
+	claude := factory(&registry.Agent{ID: "agent-a", Profile: registry.Profile{Workspace: &registry.Workspace{}}})
+	if dir := claude.(*broker.ClaudeExecutor).Profile.WorkDir; dir != wsMgr.Path("agent-a") {
+		t.Errorf("expected claude to run in the workspace, got %q", dir)
+	}
+
+	command := factory(&registry.Agent{ID: "agent-b", Profile: registry.Profile{
+		Workspace: &registry.Workspace{},
+		Command:   &registry.Command{Argv: []string{"bot"}},
+	}})
+	if dir := command.(*broker.CommandExecutor).WorkDir; dir != wsMgr.Path("agent-b") {
+		t.Errorf("expected the command to run in the workspace, got %q", dir)
+	}
+
+	plain := factory(&registry.Agent{ID: "agent-c", Profile: registry.Profile{WorkDir: "/srv"}})
+	if dir := plain.(*broker.ClaudeExecutor).Profile.WorkDir; dir != "/srv" {
+		t.Errorf("expected the work dir kept, got %q", dir)
+	}
+}
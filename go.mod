@@ -2,4 +2,4 @@ module github.com/binaryphile/cc-bridge
 
 go 1.24.4
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
@@ -0,0 +1,243 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// BatchExecutor is implemented by executors that can answer several queued
+// messages in a single turn
+type BatchExecutor interface {
+	ExecuteBatch(ctx context.Context, sessionID string, messages []BatchMessage, isNew bool) (*ExecuteResult, error)
+}
+
+// BatchMessage is one message of a batched turn, attributed to its sender
+type BatchMessage struct {
+	From string
+	Text string
+}
+
+// BatchInput encodes messages as a single stream-json user message with one
+// text block per message
+func BatchInput(messages []BatchMessage) ([]byte, error) {
+	type block struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	type userMessage struct {
+		Role    string  `json:"role"`
+		Content []block `json:"content"`
+	}
+	type line struct {
+		Type    string      `json:"type"`
+		Message userMessage `json:"message"`
+	}
+
+	content := make([]block, 0, len(messages)+1)
+	content = append(content, block{
+		Type: "text",
+		Text: fmt.Sprintf("You have %d new messages. Read them all, then reply once.", len(messages)),
+	})
+	for _, m := range messages {
+		content = append(content, block{Type: "text", Text: fmt.Sprintf("[message from %s]\n%s", m.From, m.Text)})
+	}
+
+	data, err := json.Marshal(line{Type: "user", Message: userMessage{Role: "user", Content: content}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// SetBatchSize lets an agent answer up to n queued messages in one turn when
+// its executor implements BatchExecutor. One or less turns batching off.
+func (b *Broker) SetBatchSize(n int) {
+	b.batchSize = n
+}
+
+// ProcessBatch leases up to the batch size of an agent's due messages and
+// answers them in a single turn. Each distinct sender gets a copy of the
+// reply. A single pending message is processed as by ProcessNext.
+func (b *Broker) ProcessBatch(ctx context.Context, agentID string) ([]*schema.Message, error) {
+	q, err := b.queueMgr.GetQueue(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue: %w", err)
+	}
+
+	exec, ok := b.executorFor(agentID).(BatchExecutor)
+	limit := b.batchSize
+	if !ok {
+		limit = 1
+	}
+
+	var leases []*queue.Lease
+	for len(leases) < max(limit, 1) {
+		lease, err := q.Lease(b.leaseTimeout)
+		if err != nil {
+			if len(leases) == 0 {
+				return nil, fmt.Errorf("failed to lease: %w", err)
+			}
+			// Answer what is already leased; the rest waits for the next turn
+			if b.errorHandler != nil {
+				b.errorHandler(agentID, fmt.Errorf("failed to lease: %w", err))
+			}
+			break
+		}
+		if lease == nil {
+			break
+		}
+		leases = append(leases, lease)
+	}
+
+	switch len(leases) {
+	case 0:
+		return nil, nil
+	case 1:
+		response, err := b.processLease(ctx, q, agentID, leases[0])
+		if response == nil {
+			return nil, err
+		}
+		return []*schema.Message{response}, err
+	}
+	return b.processBatch(ctx, q, agentID, exec, leases)
+}
+
+func (b *Broker) processBatch(ctx context.Context, q *queue.Queue, agentID string, exec BatchExecutor, leases []*queue.Lease) ([]*schema.Message, error) {
+	failAll := func(cause error) error {
+		errs := make([]error, len(leases))
+		for i, lease := range leases {
+			errs[i] = b.fail(q, agentID, lease, cause)
+		}
+		return errors.Join(errs...)
+	}
+
+	sess, err := b.sessionMgr.GetSession(agentID)
+	if err != nil {
+		return nil, failAll(fmt.Errorf("failed to get session: %w", err))
+	}
+	if sess.PendingFork {
+		forking, err := b.turnExecutor(agentID, sess)
+		if err != nil {
+			return nil, failAll(err)
+		}
+		batch, ok := forking.(BatchExecutor)
+		if !ok {
+			return nil, failAll(fmt.Errorf("forking executor for %s cannot batch", agentID))
+		}
+		exec = batch
+	}
+
+	messages := make([]BatchMessage, len(leases))
+	for i, lease := range leases {
+		messages[i] = BatchMessage{From: lease.Message.From, Text: lease.Message.Payload.Text}
+	}
+
+	for _, lease := range leases {
+		b.record(agentID, history.EventStarted, lease.Message)
+	}
+	result, err := b.executeBatch(ctx, agentID, exec, sess.SessionID, messages, sess.SessionID == "")
+	if err != nil {
+		if ctx.Err() != nil {
+			for _, lease := range leases {
+				if qerr := q.Nack(lease); qerr != nil {
+					return nil, fmt.Errorf("failed to release interrupted message: %w", qerr)
+				}
+			}
+			return nil, fmt.Errorf("turn interrupted: %w", err)
+		}
+		return nil, failAll(fmt.Errorf("failed to execute batch: %w", err))
+	}
+	if result.IsError {
+		return nil, failAll(b.resultError(agentID, sess, result))
+	}
+
+	for _, lease := range leases {
+		b.record(agentID, history.EventProcessed, lease.Message)
+	}
+
+	turn, err := b.updateSession(agentID, sess, result)
+	if err != nil {
+		return nil, failAll(err)
+	}
+
+	// One reply per sender, answering that sender's latest message; batch_ids
+	// lists all of the sender's messages it answers. Broadcast copies are
+	// answered through their aggregate instead.
+	var responses []*schema.Message
+	bySender := make(map[string]*schema.Message)
+	pending := make(map[string][]*queue.Lease)
+	var collected []*queue.Lease
+	for _, lease := range leases {
+		msg := lease.Message
+		response, ok := bySender[msg.From]
+		if !ok {
+			response = newResponse(agentID, msg.From, result, turn)
+			response.WithMetadata("batch_size", fmt.Sprint(len(leases)))
+			bySender[msg.From] = response
+			responses = append(responses, response)
+		}
+		if ids := response.Payload.Metadata["batch_ids"]; ids != "" {
+			response.WithMetadata("batch_ids", ids+","+msg.ID)
+		} else {
+			response.WithMetadata("batch_ids", msg.ID)
+		}
+		response.InReplyTo = msg.ID
+		response.Hops = max(response.Hops, msg.Hops+1)
+
+		if msg.CorrelationID != "" && b.collect(msg.CorrelationID, agentID, result.Response, nil) {
+			collected = append(collected, lease)
+		} else {
+			pending[msg.From] = append(pending[msg.From], lease)
+		}
+	}
+
+	// Messages are acked as soon as their reply is delivered, so a routing
+	// failure only sends the senders not yet answered back for a retry
+	for _, lease := range collected {
+		if err := q.Ack(lease); err != nil {
+			return nil, fmt.Errorf("failed to ack message: %w", err)
+		}
+	}
+	for i, response := range responses {
+		senderLeases := pending[response.To]
+		if b.routing && len(senderLeases) > 0 {
+			if err := b.route(response); err != nil {
+				var unanswered []error
+				for _, later := range responses[i:] {
+					for _, lease := range pending[later.To] {
+						unanswered = append(unanswered, b.fail(q, agentID, lease, err))
+					}
+				}
+				return nil, errors.Join(unanswered...)
+			}
+		}
+		for _, lease := range senderLeases {
+			if err := q.Ack(lease); err != nil {
+				return nil, fmt.Errorf("failed to ack message: %w", err)
+			}
+		}
+		b.record(agentID, history.EventResponse, response)
+	}
+	return responses, nil
+}
+
+// executeBatch runs a batched turn once a concurrency slot is available
+func (b *Broker) executeBatch(ctx context.Context, agentID string, exec BatchExecutor, sessionID string, messages []BatchMessage, isNew bool) (*ExecuteResult, error) {
+	release, err := b.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	turnCtx, cancel, timeout := b.withTurnTimeout(ctx, agentID)
+	defer cancel()
+	result, err := exec.ExecuteBatch(turnCtx, sessionID, messages, isNew)
+	b.spend(agentID, result)
+	return result, timeoutError(turnCtx, err, timeout)
+}
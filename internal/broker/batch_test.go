@@ -0,0 +1,243 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// BatchMockExecutor records single and batched calls
+type BatchMockExecutor struct {
+	MockExecutor
+	batches [][]BatchMessage
+	fail    bool
+}
+
+func (m *BatchMockExecutor) ExecuteBatch(ctx context.Context, sessionID string, messages []BatchMessage, isNew bool) (*ExecuteResult, error) {
+	m.batches = append(m.batches, messages)
+	if m.fail {
+		return nil, errors.New("batch exploded")
+	}
+	return &ExecuteResult{SessionID: "batch-session", Response: "batched reply"}, nil
+}
+
+func sendFrom(b *Broker, from, text string) *schema.Message {
+	msg := schema.NewMessage(from, schema.AgentA, schema.TypeMessage, text)
+	b.SendMessage(msg)
+	time.Sleep(time.Millisecond) // Ensure distinct timestamps
+	return msg
+}
+
+func TestProcessBatch_OneTurnForAllPending(t *testing.T) {
+	executor := &BatchMockExecutor{}
+	b, qMgr, _ := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(10)
+
+	first := sendFrom(b, schema.Human, "first")
+	second := sendFrom(b, schema.AgentB, "second")
+	last := sendFrom(b, schema.Human, "third")
+
+	responses, err := b.ProcessBatch(context.Background(), schema.AgentA)
+	if err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+
+	if len(executor.batches) != 1 || len(executor.calls) != 0 {
+		t.Fatalf("expected one batched call, got %d batches and %d single calls", len(executor.batches), len(executor.calls))
+	}
+	batch := executor.batches[0]
+	if len(batch) != 3 || batch[0].Text != "first" || batch[1].From != schema.AgentB || batch[2].Text != "third" {
+		t.Errorf("unexpected batch: %+v", batch)
+	}
+
+	// One reply per distinct sender, answering their latest message
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(responses))
+	}
+	if responses[0].To != schema.Human || responses[0].InReplyTo != last.ID {
+		t.Errorf("expected reply to human's latest message, got %+v", responses[0])
+	}
+	if responses[1].To != schema.AgentB {
+		t.Errorf("expected reply to agent-b, got %s", responses[1].To)
+	}
+	if responses[0].Context.TurnNumber != 1 || responses[1].Context.TurnNumber != 1 {
+		t.Errorf("expected both replies to carry turn 1, got %+v and %+v", responses[0].Context, responses[1].Context)
+	}
+	if responses[0].Payload.Metadata["batch_size"] != "3" {
+		t.Errorf("expected batch_size 3, got %v", responses[0].Payload.Metadata)
+	}
+	if ids := responses[0].Payload.Metadata["batch_ids"]; ids != first.ID+","+last.ID {
+		t.Errorf("expected batch_ids to list both of human's messages, got %q", ids)
+	}
+	if ids := responses[1].Payload.Metadata["batch_ids"]; ids != second.ID {
+		t.Errorf("expected batch_ids %q, got %q", second.ID, ids)
+	}
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("expected queue empty, got %d", n)
+	}
+	if leases, _ := q.InFlight(); len(leases) != 0 {
+		t.Errorf("expected all messages acked, got %d in flight", len(leases))
+	}
+}
+
+func TestProcessBatch_RespectsBatchSize(t *testing.T) {
+	executor := &BatchMockExecutor{}
+	b, qMgr, _ := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(2)
+
+	for _, text := range []string{"1", "2", "3"} {
+		sendFrom(b, schema.Human, text)
+	}
+
+	b.ProcessBatch(context.Background(), schema.AgentA)
+
+	if len(executor.batches) != 1 || len(executor.batches[0]) != 2 {
+		t.Fatalf("expected a batch of 2, got %+v", executor.batches)
+	}
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("expected 1 message left, got %d", n)
+	}
+}
+
+func TestProcessBatch_SingleMessageUsesExecute(t *testing.T) {
+	executor := &BatchMockExecutor{}
+	b, _, _ := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(10)
+
+	sendFrom(b, schema.Human, "alone")
+	responses, _ := b.ProcessBatch(context.Background(), schema.AgentA)
+
+	if len(executor.batches) != 0 || len(executor.calls) != 1 {
+		t.Errorf("expected a plain turn, got %d batches and %d single calls", len(executor.batches), len(executor.calls))
+	}
+	if len(responses) != 1 {
+		t.Errorf("expected 1 response, got %d", len(responses))
+	}
+}
+
+func TestProcessBatch_FallsBackWithoutBatchExecutor(t *testing.T) {
+	executor := &MockExecutor{}
+	b, qMgr, _ := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(10)
+
+	sendFrom(b, schema.Human, "1")
+	sendFrom(b, schema.Human, "2")
+
+	b.ProcessBatch(context.Background(), schema.AgentA)
+
+	if len(executor.calls) != 1 {
+		t.Errorf("expected one message per turn, got %d calls", len(executor.calls))
+	}
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("expected 1 message left, got %d", n)
+	}
+}
+
+func TestProcessBatch_FailureRetriesEveryMessage(t *testing.T) {
+	executor := &BatchMockExecutor{fail: true}
+	b, qMgr, _ := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(10)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	sendFrom(b, schema.Human, "1")
+	sendFrom(b, schema.AgentB, "2")
+
+	if _, err := b.ProcessBatch(context.Background(), schema.AgentA); err == nil {
+		t.Fatal("expected error from failed batch")
+	}
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := q.List()
+	if len(msgs) != 2 {
+		t.Fatalf("expected both messages requeued, got %d", len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.Attempts != 1 {
+			t.Errorf("expected 1 attempt recorded, got %d", msg.Attempts)
+		}
+	}
+}
+
+func TestProcessBatch_RouteFailureRetriesOnlyUnanswered(t *testing.T) {
+	executor := &BatchMockExecutor{}
+	b, qMgr, dir := newTestBroker(t, executor, schema.AgentA)
+	b.SetBatchSize(10)
+	b.SetRouting(true, 0)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	fromHuman := sendFrom(b, schema.Human, "1")
+	fromB := sendFrom(b, schema.AgentB, "2")
+
+	// Routing the second reply fails because agent-b's queue can't be created
+	os.WriteFile(filepath.Join(dir, "queues", schema.AgentB), nil, 0644)
+
+	if _, err := b.ProcessBatch(context.Background(), schema.AgentA); err == nil {
+		t.Fatal("expected error from failed route")
+	}
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := q.List()
+	if len(msgs) != 1 || msgs[0].ID != fromB.ID || msgs[0].Attempts != 1 {
+		t.Fatalf("expected only agent-b's message requeued, got %+v", msgs)
+	}
+	if _, err := q.Get(fromHuman.ID); !errors.Is(err, queue.ErrNotFound) {
+		t.Errorf("expected the answered message acked, got %v", err)
+	}
+	inbox, _ := qMgr.GetQueue(schema.Human)
+	if n, _ := inbox.Len(); n != 1 {
+		t.Errorf("expected one reply delivered to human, got %d", n)
+	}
+}
+
+func TestBatchInput(t *testing.T) {
+	data, err := BatchInput([]BatchMessage{
+		{From: schema.Human, Text: "hello"},
+		{From: schema.AgentB, Text: "hi there"},
+	})
+	if err != nil {
+		t.Fatalf("BatchInput failed: %v", err)
+	}
+	if strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected a single JSON line, got %q", data)
+	}
+
+	var line struct {
+		Type    string `json:"type"`
+		Message struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if line.Type != "user" || line.Message.Role != "user" {
+		t.Errorf("expected a user message, got %q/%q", line.Type, line.Message.Role)
+	}
+
+	content := line.Message.Content
+	if len(content) != 3 {
+		t.Fatalf("expected a preamble and 2 message blocks, got %d", len(content))
+	}
+	if !strings.Contains(content[1].Text, "human") || !strings.Contains(content[1].Text, "hello") {
+		t.Errorf("expected sender attribution, got %q", content[1].Text)
+	}
+	if !strings.Contains(content[2].Text, "agent-b") {
+		t.Errorf("expected sender attribution, got %q", content[2].Text)
+	}
+}
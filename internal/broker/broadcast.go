@@ -0,0 +1,303 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// broadcastRetention is how long a completed aggregate is kept for late
+// WaitBroadcast callers
+const broadcastRetention = 10 * time.Minute
+
+// broadcast tracks the copies of one broadcast message until every
+// recipient has replied or failed
+type broadcast struct {
+	msg      *schema.Message // Nil until fanOut registers the broadcast
+	pending  map[string]bool
+	replies  map[string]string
+	errors   map[string]string
+	result   *schema.Message
+	done     chan struct{}
+	finished time.Time
+	waiters  int
+}
+
+// broadcasts holds in-flight and recently completed broadcasts by ID.
+// State is kept in memory only; copies of a broadcast that outlive a broker
+// restart are answered individually instead of being aggregated.
+type broadcasts struct {
+	mu   sync.Mutex
+	byID map[string]*broadcast
+}
+
+// entry returns the tracking state for id, creating it if needed.
+// Must be called with mu held.
+func (t *broadcasts) entry(id string) *broadcast {
+	if t.byID == nil {
+		t.byID = make(map[string]*broadcast)
+	}
+	bc, ok := t.byID[id]
+	if !ok {
+		bc = &broadcast{done: make(chan struct{})}
+		t.byID[id] = bc
+	}
+	return bc
+}
+
+// prune drops completed broadcasts older than broadcastRetention.
+// Must be called with mu held.
+func (t *broadcasts) prune(now time.Time) {
+	for id, bc := range t.byID {
+		if bc.result != nil && now.Sub(bc.finished) > broadcastRetention {
+			delete(t.byID, id)
+		}
+	}
+}
+
+// WaitBroadcast blocks until every recipient of the broadcast message with
+// the given ID has replied or been dead-lettered, and returns the aggregated
+// result. The aggregate is sent from schema.System to the original sender
+// with one section per recipient, so a reply to it is not broadcast again.
+// WaitBroadcast may be called before the broadcast is fanned out; if it
+// never is, the entry is dropped once the last waiter gives up.
+func (b *Broker) WaitBroadcast(ctx context.Context, id string) (*schema.Message, error) {
+	t := &b.broadcasts
+	t.mu.Lock()
+	bc := t.entry(id)
+	bc.waiters++
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		bc.waiters--
+		if bc.waiters == 0 && bc.msg == nil && t.byID[id] == bc {
+			delete(t.byID, id)
+		}
+	}()
+
+	select {
+	case <-bc.done:
+		return bc.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// drainBroadcast fans out every message in the broadcast queue
+func (b *Broker) drainBroadcast(ctx context.Context, _ string) {
+	q, err := b.queueMgr.GetQueue(schema.Broadcast)
+	if err != nil {
+		if b.errorHandler != nil {
+			b.errorHandler(schema.Broadcast, fmt.Errorf("failed to get queue: %w", err))
+		}
+		return
+	}
+
+	for ctx.Err() == nil {
+		lease, err := q.Lease(b.leaseTimeout)
+		if err != nil {
+			if b.errorHandler != nil {
+				b.errorHandler(schema.Broadcast, fmt.Errorf("failed to lease: %w", err))
+			}
+			return
+		}
+		if lease == nil {
+			return
+		}
+
+		if err := b.fanOut(lease.Message); err != nil {
+			err = b.fail(q, schema.Broadcast, lease, err)
+			if b.errorHandler != nil {
+				b.errorHandler(schema.Broadcast, err)
+			}
+			return
+		}
+		b.record(schema.Broadcast, history.EventProcessed, lease.Message)
+
+		if err := q.Ack(lease); err != nil {
+			if b.errorHandler != nil {
+				b.errorHandler(schema.Broadcast, fmt.Errorf("failed to ack message: %w", err))
+			}
+			return
+		}
+	}
+}
+
+// fanOut enqueues a copy of msg for every active agent except the sender.
+// Each copy carries the original ID as its correlation ID. A redelivered
+// broadcast keeps the replies collected so far and only sends copies to
+// recipients that are still pending and do not already have theirs.
+func (b *Broker) fanOut(msg *schema.Message) error {
+	// Register before enqueuing so a fast reply can't arrive untracked
+	b.broadcasts.mu.Lock()
+	b.broadcasts.prune(time.Now())
+	bc := b.broadcasts.entry(msg.ID)
+	if bc.result != nil {
+		// Redelivered after it already completed
+		b.broadcasts.mu.Unlock()
+		return nil
+	}
+	if bc.msg == nil {
+		bc.msg = msg
+		bc.pending = make(map[string]bool)
+		bc.replies = make(map[string]string)
+		bc.errors = make(map[string]string)
+		for _, agent := range b.Agents() {
+			if agent != msg.From && b.acceptsBroadcasts(agent) {
+				bc.pending[agent] = true
+			}
+		}
+	}
+	recipients := make([]string, 0, len(bc.pending))
+	for agent := range bc.pending {
+		recipients = append(recipients, agent)
+	}
+	b.broadcasts.mu.Unlock()
+
+	if len(recipients) == 0 {
+		b.complete(bc)
+		return nil
+	}
+
+	sort.Strings(recipients)
+	for _, agent := range recipients {
+		id := copyID(msg.ID, agent)
+		sent, err := b.holds(agent, id)
+		if err != nil {
+			return fmt.Errorf("failed to fan out to %s: %w", agent, err)
+		}
+		if sent {
+			continue
+		}
+
+		cp := schema.NewMessage(msg.From, agent, msg.Type, msg.Payload.Text)
+		cp.ID = id
+		for k, v := range msg.Payload.Metadata {
+			cp.WithMetadata(k, v)
+		}
+		cp.Hops = msg.Hops
+		cp.CorrelationID = msg.ID
+		if err := b.SendMessage(cp); err != nil {
+			return fmt.Errorf("failed to fan out to %s: %w", agent, err)
+		}
+	}
+	return nil
+}
+
+// copyID derives the ID of the copy of a broadcast sent to agent, so a
+// redelivered broadcast can tell which copies were already sent
+func copyID(broadcastID, agent string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(broadcastID+"/"+agent)).String()
+}
+
+// holds reports whether the message with the given ID is queued for or
+// leased by agent
+func (b *Broker) holds(agent, id string) (bool, error) {
+	q, err := b.queueMgr.GetQueue(agent)
+	if err != nil {
+		return false, err
+	}
+	if _, err := q.Get(id); err == nil {
+		return true, nil
+	} else if !errors.Is(err, queue.ErrNotFound) {
+		return false, err
+	}
+
+	leases, err := q.InFlight()
+	if err != nil {
+		return false, err
+	}
+	for _, l := range leases {
+		if l.Message.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collect records one recipient's outcome for a broadcast. It reports false
+// if the broadcast is not being tracked, in which case the caller should
+// handle the reply as an ordinary message.
+func (b *Broker) collect(correlationID, agent, reply string, failure error) bool {
+	b.broadcasts.mu.Lock()
+	bc, ok := b.broadcasts.byID[correlationID]
+	if !ok || bc.msg == nil || !bc.pending[agent] {
+		b.broadcasts.mu.Unlock()
+		return false
+	}
+	delete(bc.pending, agent)
+	if failure != nil {
+		bc.errors[agent] = failure.Error()
+	} else {
+		bc.replies[agent] = reply
+	}
+	finished := len(bc.pending) == 0
+	b.broadcasts.mu.Unlock()
+
+	if finished {
+		b.complete(bc)
+	}
+	return true
+}
+
+// complete builds the aggregated result for a broadcast, delivers it and
+// wakes any waiters
+func (b *Broker) complete(bc *broadcast) {
+	b.broadcasts.mu.Lock()
+	agents := make([]string, 0, len(bc.replies)+len(bc.errors))
+	for agent := range bc.replies {
+		agents = append(agents, agent)
+	}
+	for agent := range bc.errors {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+
+	var text strings.Builder
+	for i, agent := range agents {
+		if i > 0 {
+			text.WriteString("\n\n")
+		}
+		if errText, failed := bc.errors[agent]; failed {
+			fmt.Fprintf(&text, "[%s] failed: %s", agent, errText)
+		} else {
+			fmt.Fprintf(&text, "[%s]\n%s", agent, bc.replies[agent])
+		}
+	}
+
+	orig := bc.msg
+	result := schema.NewMessage(schema.System, orig.From, schema.TypeMessage, text.String())
+	result.InReplyTo = orig.ID
+	result.CorrelationID = orig.ID
+	result.Hops = orig.Hops + 1
+	result.WithMetadata("replies", strconv.Itoa(len(bc.replies)))
+	result.WithMetadata("errors", strconv.Itoa(len(bc.errors)))
+
+	bc.result = result
+	bc.finished = time.Now()
+	b.broadcasts.mu.Unlock()
+
+	b.record(schema.Broadcast, history.EventResponse, result)
+	if b.routing {
+		if err := b.route(result); err != nil && b.errorHandler != nil {
+			b.errorHandler(schema.Broadcast, err)
+		}
+	}
+	if b.handler != nil {
+		b.handler(result)
+	}
+	close(bc.done)
+}
@@ -0,0 +1,208 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestBroadcast_FansOutToAllButSender(t *testing.T) {
+	b, qMgr, _ := newTestBroker(t, &MockExecutor{}, schema.AgentA, schema.AgentB, "agent-c")
+
+	msg := schema.NewAgentMessage(schema.AgentA, schema.Broadcast, "hello all")
+	b.SendMessage(msg)
+	b.drainBroadcast(context.Background(), schema.Broadcast)
+
+	bq, _ := qMgr.GetQueue(schema.Broadcast)
+	if n, _ := bq.Len(); n != 0 {
+		t.Errorf("expected broadcast queue drained, got %d", n)
+	}
+
+	for agent, want := range map[string]int{schema.AgentA: 0, schema.AgentB: 1, "agent-c": 1} {
+		q, _ := qMgr.GetQueue(agent)
+		msgs, _ := q.List()
+		if len(msgs) != want {
+			t.Fatalf("%s: expected %d copies, got %d", agent, want, len(msgs))
+		}
+		for _, cp := range msgs {
+			if cp.CorrelationID != msg.ID {
+				t.Errorf("%s: expected correlation %q, got %q", agent, msg.ID, cp.CorrelationID)
+			}
+			if cp.From != schema.AgentA || cp.Payload.Text != "hello all" {
+				t.Errorf("%s: copy does not match original: %+v", agent, cp)
+			}
+		}
+	}
+}
+
+func TestBroadcast_AggregatesReplies(t *testing.T) {
+	b, qMgr, _ := newTestBroker(t, &MockExecutor{}, schema.AgentA, schema.AgentB)
+	b.SetRouting(true, 10)
+
+	var handled []*schema.Message
+	b.SetResponseHandler(func(m *schema.Message) { handled = append(handled, m) })
+
+	msg := schema.NewUserMessage(schema.Broadcast, "status?")
+	b.SendMessage(msg)
+	b.drainBroadcast(context.Background(), schema.Broadcast)
+
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	// Individual replies are held back until every recipient has answered
+	inbox, _ := qMgr.GetQueue(schema.Human)
+	if n, _ := inbox.Len(); n != 0 {
+		t.Fatalf("expected no delivery before all replies, got %d", n)
+	}
+
+	b.ProcessNext(context.Background(), schema.AgentB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := b.WaitBroadcast(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("WaitBroadcast failed: %v", err)
+	}
+
+	if result.From != schema.System || result.To != schema.Human || result.InReplyTo != msg.ID {
+		t.Errorf("unexpected aggregate envelope: %+v", result)
+	}
+	if result.Payload.Metadata["replies"] != "2" || result.Payload.Metadata["errors"] != "0" {
+		t.Errorf("unexpected aggregate metadata: %v", result.Payload.Metadata)
+	}
+	if !strings.Contains(result.Payload.Text, "[agent-a]") || !strings.Contains(result.Payload.Text, "[agent-b]") {
+		t.Errorf("expected a section per agent, got %q", result.Payload.Text)
+	}
+
+	msgs, _ := inbox.List()
+	if len(msgs) != 1 || msgs[0].ID != result.ID {
+		t.Fatalf("expected only the aggregate in the human inbox, got %d messages", len(msgs))
+	}
+	if len(handled) == 0 || handled[len(handled)-1].ID != result.ID {
+		t.Error("expected the aggregate to reach the response handler")
+	}
+}
+
+func TestBroadcast_DeadLetteredCopyIsReported(t *testing.T) {
+	b, _, _ := newTestBroker(t, &FailingExecutor{failures: 1}, schema.AgentA)
+
+	msg := schema.NewUserMessage(schema.Broadcast, "status?")
+	b.SendMessage(msg)
+	b.drainBroadcast(context.Background(), schema.Broadcast)
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := b.WaitBroadcast(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("WaitBroadcast failed: %v", err)
+	}
+	if result.Payload.Metadata["errors"] != "1" {
+		t.Errorf("expected 1 error, got %v", result.Payload.Metadata)
+	}
+	if !strings.Contains(result.Payload.Text, "[agent-a] failed:") {
+		t.Errorf("expected failure to be reported, got %q", result.Payload.Text)
+	}
+}
+
+func TestBroadcast_Run(t *testing.T) {
+	b, _, _ := newTestBroker(t, &SlowExecutor{delay: time.Millisecond}, schema.AgentA, schema.AgentB)
+
+	msg := schema.NewUserMessage(schema.Broadcast, "hi")
+	b.SendMessage(msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+
+	result, err := b.WaitBroadcast(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("WaitBroadcast failed: %v", err)
+	}
+	if result.Payload.Metadata["replies"] != "2" {
+		t.Errorf("expected 2 replies, got %v", result.Payload.Metadata)
+	}
+}
+
+func TestBroadcast_RedeliveryKeepsProgress(t *testing.T) {
+	b, qMgr, dir := newTestBroker(t, &MockExecutor{}, schema.AgentA, schema.AgentB, "agent-c")
+
+	// With agent-c's queue directory replaced by a file, the fan-out fails
+	// after the copies for agent-a and agent-b went out
+	broken := filepath.Join(dir, "queues", "agent-c")
+	os.Remove(broken)
+	os.WriteFile(broken, nil, 0644)
+
+	msg := schema.NewUserMessage(schema.Broadcast, "status?")
+	if err := b.fanOut(msg); err == nil {
+		t.Fatal("expected the fan-out to fail")
+	}
+	if _, err := b.ProcessNext(context.Background(), schema.AgentA); err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+
+	os.Remove(broken)
+	os.Mkdir(broken, 0755)
+	if err := b.fanOut(msg); err != nil {
+		t.Fatalf("redelivered fan-out failed: %v", err)
+	}
+	for agent, want := range map[string]int{schema.AgentA: 0, schema.AgentB: 1, "agent-c": 1} {
+		q, _ := qMgr.GetQueue(agent)
+		if n, _ := q.Len(); n != want {
+			t.Errorf("%s: expected %d copies after redelivery, got %d", agent, want, n)
+		}
+	}
+
+	b.ProcessNext(context.Background(), schema.AgentB)
+	b.ProcessNext(context.Background(), "agent-c")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := b.WaitBroadcast(ctx, msg.ID)
+	if err != nil {
+		t.Fatalf("WaitBroadcast failed: %v", err)
+	}
+	if result.Payload.Metadata["replies"] != "3" {
+		t.Errorf("expected the reply collected before redelivery to be kept, got %v", result.Payload.Metadata)
+	}
+}
+
+func TestBroadcast_WaitOnUnknownIDIsForgotten(t *testing.T) {
+	b, _, _ := newTestBroker(t, &MockExecutor{}, schema.AgentA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.WaitBroadcast(ctx, "no-such-broadcast"); err == nil {
+		t.Fatal("expected WaitBroadcast on an unknown ID to time out")
+	}
+	if n := len(b.broadcasts.byID); n != 0 {
+		t.Errorf("expected no state left for an unknown broadcast, got %d entries", n)
+	}
+}
+
+func TestBroadcast_ReplyToAggregateIsNotBroadcast(t *testing.T) {
+	b, qMgr, _ := newTestBroker(t, &MockExecutor{}, schema.AgentA, schema.AgentB)
+	b.SetRouting(true, 10)
+
+	msg := schema.NewAgentMessage(schema.AgentA, schema.Broadcast, "status?")
+	b.SendMessage(msg)
+	b.drainBroadcast(context.Background(), schema.Broadcast)
+	b.ProcessNext(context.Background(), schema.AgentB)
+
+	// agent-a answers the aggregate it was sent
+	aq, _ := qMgr.GetQueue(schema.AgentA)
+	if agg, _ := aq.Peek(); agg == nil || agg.From != schema.System {
+		t.Fatalf("expected the aggregate from %s in agent-a's queue, got %+v", schema.System, agg)
+	}
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	for _, name := range []string{schema.Broadcast, schema.System} {
+		q, _ := qMgr.GetQueue(name)
+		if n, _ := q.Len(); n != 0 {
+			t.Errorf("expected nothing routed to %s, got %d messages", name, n)
+		}
+	}
+}
@@ -2,27 +2,75 @@ package broker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/history"
 	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
 	"github.com/binaryphile/cc-bridge/internal/schema"
 	"github.com/binaryphile/cc-bridge/internal/session"
 )
 
-// Executor interface for Claude CLI execution (allows mocking)
+// Executor interface for Claude CLI execution (allows mocking).
+// A failed turn may still return a result alongside its error, so the
+// broker can record what the turn cost.
 type Executor interface {
 	Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error)
 }
 
 // ExecuteResult contains the result of executing a Claude CLI command
 type ExecuteResult struct {
-	SessionID string
-	Response  string
-	Cost      float64
+	SessionID   string        `json:"session_id"`
+	Response    string        `json:"response"`
+	Cost        float64       `json:"cost,omitempty"`
+	Usage       Usage         `json:"usage,omitzero"`
+	Duration    time.Duration `json:"duration,omitempty"`
+	APIDuration time.Duration `json:"api_duration,omitempty"`
+	NumTurns    int           `json:"num_turns,omitempty"`
+	IsError     bool          `json:"is_error,omitempty"` // claude finished but reported failure, e.g. hitting --max-turns
+	Subtype     string        `json:"subtype,omitempty"`  // "success" or the error kind, e.g. "error_max_turns"
+	Events      []Event       `json:"events,omitempty"`   // Only populated by streaming executors
 }
 
-// ResponseHandler is called when a response is received
+// Usage counts the tokens a turn consumed
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// ResultError reports a turn that claude completed with an error result
+type ResultError struct {
+	Subtype string
+	Result  string
+}
+
+func (e *ResultError) Error() string {
+	if e.Result == "" {
+		return fmt.Sprintf("claude reported an error: %s", e.Subtype)
+	}
+	return fmt.Sprintf("claude reported an error: %s: %s", e.Subtype, e.Result)
+}
+
+// DefaultLeaseTimeout is how long a message stays checked out for a turn
+// before it is returned to its queue. It should comfortably exceed the
+// longest expected turn.
+const DefaultLeaseTimeout = 10 * time.Minute
+
+// ExecutorFactory builds the executor for a registered agent, typically
+// configured from its profile
+type ExecutorFactory func(agent *registry.Agent) Executor
+
+// ResponseHandler is called when a response is received. Agents are
+// processed concurrently, so handlers may be called from several goroutines.
 type ResponseHandler func(msg *schema.Message)
 
 // ErrorHandler is called when an error occurs during processing
@@ -35,21 +83,57 @@ type Broker struct {
 	executor     Executor
 	handler      ResponseHandler
 	errorHandler ErrorHandler
-	agents       []string
+	registry     *registry.Registry
+	newExecutor  ExecutorFactory
+	routing      bool
+	maxHops      int
+	slots        chan struct{}
+	history      *history.Store
+	retry        RetryPolicy
+	leaseTimeout time.Duration
+	batchSize    int
+	turnTimeout  time.Duration
+	broadcasts   broadcasts
+	ledger       *cost.Ledger
+	budget       float64
+	runBudget    float64
+
+	heartbeatPath string
+
+	mu        sync.Mutex
+	agents    []string
+	draining  map[string]bool
+	workers   map[string]*worker
+	stopping  map[string]*worker // Removed agents whose worker has not exited yet
+	executors map[string]Executor
+	timeouts  map[string]time.Duration
+	budgets   map[string]float64
+
+	pausedAgents map[string]bool
+	stopRun      context.CancelFunc
 }
 
 // NewBroker creates a new broker
 func NewBroker(qMgr *queue.Manager, sMgr *session.Manager, exec Executor) (*Broker, error) {
 	return &Broker{
-		queueMgr:   qMgr,
-		sessionMgr: sMgr,
-		executor:   exec,
+		queueMgr:     qMgr,
+		sessionMgr:   sMgr,
+		executor:     exec,
+		leaseTimeout: DefaultLeaseTimeout,
+		draining:     make(map[string]bool),
+		workers:      make(map[string]*worker),
+		stopping:     make(map[string]*worker),
+		executors:    make(map[string]Executor),
+		timeouts:     make(map[string]time.Duration),
+		budgets:      make(map[string]float64),
+		pausedAgents: make(map[string]bool),
 	}, nil
 }
 
-// InitializeAgent creates a session and queue for an agent
+// InitializeAgent creates a session and queue for an agent. An existing
+// session, e.g. one loaded from disk, is resumed rather than replaced.
 func (b *Broker) InitializeAgent(agentID string) error {
-	_, err := b.sessionMgr.CreateSession(agentID)
+	_, err := b.sessionMgr.GetOrCreateSession(agentID)
 	if err != nil {
 		return fmt.Errorf("failed to create session for %s: %w", agentID, err)
 	}
@@ -60,10 +144,144 @@ func (b *Broker) InitializeAgent(agentID string) error {
 	}
 
 	// Track this agent for polling
-	b.agents = append(b.agents, agentID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !slices.Contains(b.agents, agentID) {
+		b.agents = append(b.agents, agentID)
+	}
 	return nil
 }
 
+// RemoveAgent stops tracking an agent and stops its worker if Run is active.
+// A turn in progress is interrupted and its message returned to the queue.
+// The agent's queue and session are left on disk. If the agent is added
+// again, its new worker waits for the old one to exit.
+func (b *Broker) RemoveAgent(agentID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.agents = slices.DeleteFunc(b.agents, func(a string) bool { return a == agentID })
+	delete(b.draining, agentID)
+	delete(b.executors, agentID)
+	delete(b.timeouts, agentID)
+	delete(b.budgets, agentID)
+	delete(b.pausedAgents, agentID)
+	if w, ok := b.workers[agentID]; ok {
+		w.cancel()
+		delete(b.workers, agentID)
+		b.stopping[agentID] = w
+	}
+}
+
+// SetRegistry makes reg the source of truth for which agents run. Run starts
+// and stops workers as agents are added to or removed from the registry,
+// and unregisters draining agents once their queues are empty.
+func (b *Broker) SetRegistry(reg *registry.Registry) {
+	b.registry = reg
+}
+
+// SetExecutorFactory makes Run build each registered agent's executor from
+// its registry entry. Executors are rebuilt whenever the registry is re-read,
+// so profile changes apply from the agent's next turn.
+func (b *Broker) SetExecutorFactory(f ExecutorFactory) {
+	b.newExecutor = f
+}
+
+// SetAgentExecutor overrides the broker's executor for one agent
+func (b *Broker) SetAgentExecutor(agentID string, exec Executor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.executors[agentID] = exec
+}
+
+// executorFor returns the agent's own executor, or the broker's default
+func (b *Broker) executorFor(agentID string) Executor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if exec, ok := b.executors[agentID]; ok {
+		return exec
+	}
+	return b.executor
+}
+
+// SetTurnTimeout limits how long a single turn may run. A turn that runs
+// over is stopped and retried like any other failure. Zero means no limit.
+func (b *Broker) SetTurnTimeout(d time.Duration) {
+	b.turnTimeout = d
+}
+
+// SetAgentTurnTimeout overrides the turn timeout for one agent. Zero
+// restores the broker's default.
+func (b *Broker) SetAgentTurnTimeout(agentID string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d > 0 {
+		b.timeouts[agentID] = d
+	} else {
+		delete(b.timeouts, agentID)
+	}
+}
+
+// withTurnTimeout bounds ctx by the agent's turn timeout, if any
+func (b *Broker) withTurnTimeout(ctx context.Context, agentID string) (context.Context, context.CancelFunc, time.Duration) {
+	b.mu.Lock()
+	timeout, ok := b.timeouts[agentID]
+	b.mu.Unlock()
+	if !ok {
+		timeout = b.turnTimeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}, 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
+// timeoutError reports err as a *TimeoutError if the turn ran out of time
+func timeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		return &TimeoutError{Timeout: timeout}
+	}
+	if te.Timeout == 0 {
+		te.Timeout = timeout
+	}
+	return err
+}
+
+// SetRouting controls whether responses are delivered to the queue of the
+// agent they are addressed to. Responses addressed to schema.Human land in
+// the human inbox queue. maxHops limits how many replies a conversation may
+// chain between agents before routing stops; zero means no limit.
+func (b *Broker) SetRouting(enabled bool, maxHops int) {
+	b.routing = enabled
+	b.maxHops = maxHops
+}
+
+// SetMaxConcurrent caps how many executor calls may run at once across all
+// agents. Zero or less means no cap. Must be called before Run.
+func (b *Broker) SetMaxConcurrent(n int) {
+	if n <= 0 {
+		b.slots = nil
+		return
+	}
+	b.slots = make(chan struct{}, n)
+}
+
+// SetLeaseTimeout sets how long a message may stay in flight before it is
+// redelivered
+func (b *Broker) SetLeaseTimeout(d time.Duration) {
+	b.leaseTimeout = d
+}
+
+// SetHistory records every enqueued, processed and response message to h
+func (b *Broker) SetHistory(h *history.Store) {
+	b.history = h
+}
+
 // SetErrorHandler sets the callback for errors
 func (b *Broker) SetErrorHandler(handler ErrorHandler) {
 	b.errorHandler = handler
@@ -71,7 +289,9 @@ func (b *Broker) SetErrorHandler(handler ErrorHandler) {
 
 // Agents returns the list of registered agents
 func (b *Broker) Agents() []string {
-	return b.agents
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.agents)
 }
 
 // SendMessage sends a message to an agent's queue
@@ -80,7 +300,11 @@ func (b *Broker) SendMessage(msg *schema.Message) error {
 	if err != nil {
 		return fmt.Errorf("failed to get queue for %s: %w", msg.To, err)
 	}
-	return q.Enqueue(msg)
+	if err := q.Enqueue(msg); err != nil {
+		return err
+	}
+	b.record(msg.To, history.EventEnqueued, msg)
+	return nil
 }
 
 // ProcessNext processes the next message for an agent
@@ -90,39 +314,185 @@ func (b *Broker) ProcessNext(ctx context.Context, agentID string) (*schema.Messa
 		return nil, fmt.Errorf("failed to get queue: %w", err)
 	}
 
-	msg, err := q.Dequeue()
+	// The message stays leased until its turn is fully accounted for, so a
+	// crash anywhere below returns it to the queue once the lease expires
+	lease, err := q.Lease(b.leaseTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dequeue: %w", err)
+		return nil, fmt.Errorf("failed to lease: %w", err)
 	}
-	if msg == nil {
+	if lease == nil {
 		return nil, nil // No messages
 	}
+	return b.processLease(ctx, q, agentID, lease)
+}
+
+// processLease runs one turn for a leased message and acks it once the
+// response is recorded and routed
+func (b *Broker) processLease(ctx context.Context, q *queue.Queue, agentID string, lease *queue.Lease) (*schema.Message, error) {
+	msg := lease.Message
 
 	sess, err := b.sessionMgr.GetSession(agentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get session: %w", err)
+		return nil, b.fail(q, agentID, lease, fmt.Errorf("failed to get session: %w", err))
 	}
 
+	exec, err := b.turnExecutor(agentID, sess)
+	if err != nil {
+		return nil, b.fail(q, agentID, lease, err)
+	}
+
+	b.record(agentID, history.EventStarted, msg)
 	isNew := sess.SessionID == ""
-	result, err := b.executor.Execute(ctx, sess.SessionID, msg.Payload.Text, isNew)
+	result, err := b.execute(ctx, agentID, exec, sess.SessionID, msg.Payload.Text, isNew)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute: %w", err)
+		if ctx.Err() != nil {
+			// Interrupted by shutdown; put the message back without using an attempt
+			if qerr := q.Nack(lease); qerr != nil {
+				return nil, fmt.Errorf("failed to release interrupted message: %w", qerr)
+			}
+			return nil, fmt.Errorf("turn interrupted: %w", err)
+		}
+		return nil, b.fail(q, agentID, lease, fmt.Errorf("failed to execute: %w", err))
 	}
+	if result.IsError {
+		return nil, b.fail(q, agentID, lease, b.resultError(agentID, sess, result))
+	}
+
+	b.record(agentID, history.EventProcessed, msg)
 
-	// Update session
-	if isNew || result.SessionID != sess.SessionID {
-		b.sessionMgr.SetSessionID(agentID, result.SessionID)
+	turn, err := b.updateSession(agentID, sess, result)
+	if err != nil {
+		return nil, b.fail(q, agentID, lease, err)
 	}
-	b.sessionMgr.IncrementTurn(agentID)
 
 	// Create response message
-	response := schema.NewAgentMessage(agentID, msg.From, result.Response)
-	response.WithContext(result.SessionID, sess.TurnNumber+1)
-	response.WithMetadata("cost", fmt.Sprintf("%.6f", result.Cost))
+	response := newResponse(agentID, msg.From, result, turn)
+	response.InReplyTo = msg.ID
+	response.Hops = msg.Hops + 1
+	response.CorrelationID = msg.CorrelationID
+
+	// Replies to a broadcast are delivered as one aggregate once all are in
+	aggregated := msg.CorrelationID != "" && b.collect(msg.CorrelationID, agentID, result.Response, nil)
+
+	if b.routing && !aggregated {
+		if err := b.route(response); err != nil {
+			return nil, b.fail(q, agentID, lease, err)
+		}
+	}
+
+	if err := q.Ack(lease); err != nil {
+		return nil, fmt.Errorf("failed to ack message: %w", err)
+	}
+	// Only now is the message answered for good; until the ack a failure
+	// sends it back for a retry, and send --wait must not see a reply yet
+	b.record(agentID, history.EventResponse, response)
 
 	return response, nil
 }
 
+// updateSession stores the session ID claude reported and advances the turn
+// counter, returning the new turn number
+func (b *Broker) updateSession(agentID string, sess *session.Session, result *ExecuteResult) (int, error) {
+	if sess.SessionID == "" || result.SessionID != sess.SessionID {
+		if err := b.sessionMgr.SetSessionID(agentID, result.SessionID); err != nil {
+			return 0, fmt.Errorf("failed to update session: %w", err)
+		}
+	}
+	if err := b.sessionMgr.IncrementTurn(agentID); err != nil {
+		return 0, fmt.Errorf("failed to update session: %w", err)
+	}
+	return sess.TurnNumber, nil
+}
+
+// resultError turns an error result into a failure. The session claude
+// reported is kept so a retry continues the same conversation.
+func (b *Broker) resultError(agentID string, sess *session.Session, result *ExecuteResult) error {
+	cause := &ResultError{Subtype: result.Subtype, Result: result.Response}
+	if result.SessionID != "" && result.SessionID != sess.SessionID {
+		if err := b.sessionMgr.SetSessionID(agentID, result.SessionID); err != nil {
+			return errors.Join(cause, fmt.Errorf("failed to update session: %w", err))
+		}
+	}
+	return cause
+}
+
+// newResponse builds the message carrying an agent's reply to "to"
+func newResponse(agentID, to string, result *ExecuteResult, turn int) *schema.Message {
+	response := schema.NewAgentMessage(agentID, to, result.Response)
+	response.WithContext(result.SessionID, turn)
+	response.WithMetadata("cost", fmt.Sprintf("%.6f", result.Cost))
+	response.WithMetadata("input_tokens", strconv.Itoa(result.Usage.InputTokens))
+	response.WithMetadata("output_tokens", strconv.Itoa(result.Usage.OutputTokens))
+	response.WithMetadata("cache_creation_input_tokens", strconv.Itoa(result.Usage.CacheCreationInputTokens))
+	response.WithMetadata("cache_read_input_tokens", strconv.Itoa(result.Usage.CacheReadInputTokens))
+	response.WithMetadata("duration_ms", strconv.FormatInt(result.Duration.Milliseconds(), 10))
+	response.WithMetadata("duration_api_ms", strconv.FormatInt(result.APIDuration.Milliseconds(), 10))
+	response.WithMetadata("num_turns", strconv.Itoa(result.NumTurns))
+	if result.Subtype != "" {
+		response.WithMetadata("subtype", result.Subtype)
+	}
+	if tools := result.ToolsUsed(); len(tools) > 0 {
+		response.WithMetadata("tools", strings.Join(tools, ","))
+	}
+	return response
+}
+
+// record appends to the history store, if any. History is an audit trail,
+// so failures are reported rather than failing the turn.
+func (b *Broker) record(agent, event string, msg *schema.Message) {
+	if b.history == nil {
+		return
+	}
+	if err := b.history.Append(event, msg); err != nil && b.errorHandler != nil {
+		b.errorHandler(agent, fmt.Errorf("failed to record history: %w", err))
+	}
+}
+
+// execute runs a turn once a concurrency slot is available
+func (b *Broker) execute(ctx context.Context, agentID string, exec Executor, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	release, err := b.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	turnCtx, cancel, timeout := b.withTurnTimeout(ctx, agentID)
+	defer cancel()
+	result, err := exec.Execute(turnCtx, sessionID, message, isNew)
+	b.spend(agentID, result)
+	return result, timeoutError(turnCtx, err, timeout)
+}
+
+// acquire waits for a concurrency slot and returns the function releasing it
+func (b *Broker) acquire(ctx context.Context) (func(), error) {
+	if b.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return func() { <-b.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// route enqueues a response to its recipient unless the hop limit is reached.
+// Replies to the human are always delivered since the inbox is never processed.
+func (b *Broker) route(response *schema.Message) error {
+	// Nothing reads the broker's own inbox; its messages cannot be answered
+	if response.To == schema.System {
+		return nil
+	}
+	if response.To != schema.Human && b.maxHops > 0 && response.Hops > b.maxHops {
+		response.WithMetadata("routing", "hop_limit")
+		return nil
+	}
+	if err := b.SendMessage(response); err != nil {
+		return fmt.Errorf("failed to route response: %w", err)
+	}
+	return nil
+}
+
 // Inject sends a message as one agent to another
 func (b *Broker) Inject(asAgent, toAgent, text string) error {
 	msg := schema.NewMessage(asAgent, toAgent, schema.TypeInject, text)
@@ -134,27 +504,232 @@ func (b *Broker) SetResponseHandler(handler ResponseHandler) {
 	b.handler = handler
 }
 
-// Run starts the polling loop
+// Run starts one worker per agent and blocks until ctx is cancelled.
+// Workers wake when their queue signals a new message and otherwise poll
+// every pollInterval.
+// Agents are processed concurrently, bounded by SetMaxConcurrent, while each
+// agent's own turns run strictly in order so --resume stays consistent.
+// A further worker fans out messages sent to schema.Broadcast.
+// With a registry, the agent set is re-read every pollInterval.
+// Run also returns once the run budget is spent.
 func (b *Broker) Run(ctx context.Context, pollInterval time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b.mu.Lock()
+	b.stopRun = cancel
+	b.mu.Unlock()
+	b.startRun()
+
+	var wg sync.WaitGroup
+	if b.heartbeatPath != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.heartbeat(ctx)
+		}()
+	}
+	start := func(agent string) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, running := b.workers[agent]; running {
+			return
+		}
+		workerCtx, cancel := context.WithCancel(ctx)
+		w := &worker{cancel: cancel, done: make(chan struct{})}
+		b.workers[agent] = w
+		prev := b.stopping[agent]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.exited(agent, w)
+			// A worker of an agent removed and added again may still be
+			// finishing a turn; two on one queue would break turn order
+			if prev != nil {
+				<-prev.done
+			}
+			b.runQueue(workerCtx, agent, pollInterval, b.drain)
+		}()
+	}
+
+	if b.registry != nil {
+		b.syncAgents(start)
+	}
+	for _, agent := range b.Agents() {
+		start(agent)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.runQueue(ctx, schema.Broadcast, pollInterval, b.drainBroadcast)
+	}()
+
+	if b.registry != nil {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+	poll:
+		for {
+			select {
+			case <-ctx.Done():
+				break poll
+			case <-ticker.C:
+				b.syncAgents(start)
+			}
+		}
+	}
+	wg.Wait()
+
+	b.mu.Lock()
+	for agent, w := range b.workers {
+		w.cancel()
+		delete(b.workers, agent)
+	}
+	b.mu.Unlock()
+}
+
+// worker is the goroutine processing one agent's queue during Run
+type worker struct {
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the worker has exited
+}
+
+// exited marks w as finished
+func (b *Broker) exited(agent string, w *worker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(w.done)
+	if b.stopping[agent] == w {
+		delete(b.stopping, agent)
+	}
+}
+
+// syncAgents brings the tracked agents in line with the registry, starting
+// workers for new agents and stopping those of removed ones
+func (b *Broker) syncAgents(start func(agent string)) {
+	agents, err := b.registry.List()
+	if err != nil {
+		if b.errorHandler != nil {
+			b.errorHandler("registry", fmt.Errorf("failed to list agents: %w", err))
+		}
+		return
+	}
+
+	registered := make(map[string]bool, len(agents))
+	for _, a := range agents {
+		if a.State == registry.StateDraining && b.drained(a.ID) {
+			if err := b.registry.Remove(a.ID); err != nil {
+				if b.errorHandler != nil {
+					b.errorHandler(a.ID, fmt.Errorf("failed to unregister drained agent: %w", err))
+				}
+			} else {
+				continue
+			}
+		}
+
+		registered[a.ID] = true
+		if err := b.InitializeAgent(a.ID); err != nil {
+			if b.errorHandler != nil {
+				b.errorHandler(a.ID, err)
+			}
+			continue
+		}
+		b.mu.Lock()
+		b.draining[a.ID] = a.State == registry.StateDraining
+		b.mu.Unlock()
+		if b.newExecutor != nil {
+			b.SetAgentExecutor(a.ID, b.newExecutor(a))
+		}
+		b.SetAgentTurnTimeout(a.ID, a.Profile.TurnTimeout)
+		b.SetAgentBudget(a.ID, a.Profile.Budget)
+		start(a.ID)
+	}
+
+	for _, agent := range b.Agents() {
+		if !registered[agent] {
+			b.RemoveAgent(agent)
+		}
+	}
+}
+
+// drained reports whether an agent has no queued or in-flight messages
+func (b *Broker) drained(agentID string) bool {
+	q, err := b.queueMgr.GetQueue(agentID)
+	if err != nil {
+		return false
+	}
+	n, err := q.Len()
+	if err != nil || n > 0 {
+		return false
+	}
+	leases, err := q.InFlight()
+	return err == nil && len(leases) == 0
+}
+
+// acceptsBroadcasts reports whether an agent should receive new broadcast
+// copies. Draining agents only finish what is already queued.
+func (b *Broker) acceptsBroadcasts(agentID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.draining[agentID]
+}
+
+// runQueue drains a single queue until ctx is cancelled. It wakes on queue
+// notifications and falls back to polling every pollInterval.
+func (b *Broker) runQueue(ctx context.Context, agent string, pollInterval time.Duration, drain func(context.Context, string)) {
+	q, err := b.queueMgr.GetQueue(agent)
+	if err != nil {
+		if b.errorHandler != nil {
+			b.errorHandler(agent, fmt.Errorf("failed to get queue: %w", err))
+		}
+		return
+	}
+
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
+	drain(ctx, agent)
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-q.Notify():
+			drain(ctx, agent)
 		case <-ticker.C:
-			for _, agent := range b.agents {
-				resp, err := b.ProcessNext(ctx, agent)
-				if err != nil {
-					if b.errorHandler != nil {
-						b.errorHandler(agent, err)
-					}
-					continue
-				}
-				if resp != nil && b.handler != nil {
-					b.handler(resp)
-				}
+			drain(ctx, agent)
+		}
+	}
+}
+
+// drain processes an agent's queued messages one turn at a time until the
+// queue is empty or an error occurs. With batching on, a turn may answer
+// several messages.
+func (b *Broker) drain(ctx context.Context, agent string) {
+	for ctx.Err() == nil {
+		if b.paused(agent) {
+			return
+		}
+		var responses []*schema.Message
+		var err error
+		if b.batchSize > 1 {
+			responses, err = b.ProcessBatch(ctx, agent)
+		} else {
+			var resp *schema.Message
+			resp, err = b.ProcessNext(ctx, agent)
+			if resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if err != nil {
+			if b.errorHandler != nil {
+				b.errorHandler(agent, err)
+			}
+			return
+		}
+		if len(responses) == 0 {
+			return
+		}
+		if b.handler != nil {
+			for _, resp := range responses {
+				b.handler(resp)
 			}
 		}
 	}
@@ -2,10 +2,17 @@ package broker
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/binaryphile/cc-bridge/internal/history"
 	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
 	"github.com/binaryphile/cc-bridge/internal/schema"
 	"github.com/binaryphile/cc-bridge/internal/session"
 )
@@ -43,6 +50,20 @@ func (m *MockExecutor) Execute(ctx context.Context, sessionID string, message st
 	}, nil
 }
 
+// newTestBroker returns a broker over fresh queues and sessions in a
+// temporary data directory, which it also returns, with agents initialized
+func newTestBroker(t *testing.T, exec Executor, agents ...string) (*Broker, *queue.Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	b, _ := NewBroker(qMgr, sMgr, exec)
+	for _, agent := range agents {
+		b.InitializeAgent(agent)
+	}
+	return b, qMgr, dir
+}
+
 func TestNewBroker(t *testing.T) {
 	dir := t.TempDir()
 	qMgr, _ := queue.NewManager(dir + "/queues")
@@ -199,6 +220,28 @@ func TestTurnIncremented(t *testing.T) {
 	}
 }
 
+func TestResponseTurnNumber(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	executor := &MockExecutor{}
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	b.InitializeAgent(schema.AgentA)
+
+	// Each reply carries the number of the turn that produced it
+	for want := 1; want <= 3; want++ {
+		b.SendMessage(schema.NewUserMessage(schema.AgentA, "msg"))
+		response, err := b.ProcessNext(context.Background(), schema.AgentA)
+		if err != nil {
+			t.Fatalf("ProcessNext failed: %v", err)
+		}
+		if response.Context == nil || response.Context.TurnNumber != want {
+			t.Errorf("expected turn %d in response context, got %+v", want, response.Context)
+		}
+	}
+}
+
 func TestInjectMessage(t *testing.T) {
 	dir := t.TempDir()
 	qMgr, _ := queue.NewManager(dir + "/queues")
@@ -266,3 +309,581 @@ func TestPollLoop(t *testing.T) {
 		t.Error("timeout waiting for response")
 	}
 }
+
+func TestRouting_ResponseEnqueuedToSender(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+	b.SetRouting(true, 0)
+
+	// Agent B receives a message from Agent A; its reply should land in A's queue
+	msg := schema.NewAgentMessage(schema.AgentA, schema.AgentB, "hi B")
+	b.SendMessage(msg)
+
+	resp, err := b.ProcessNext(context.Background(), schema.AgentB)
+	if err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	if resp.InReplyTo != msg.ID {
+		t.Errorf("expected InReplyTo=%q, got %q", msg.ID, resp.InReplyTo)
+	}
+	if resp.Hops != 1 {
+		t.Errorf("expected Hops=1, got %d", resp.Hops)
+	}
+
+	qA, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := qA.List()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 routed message in agent-a queue, got %d", len(msgs))
+	}
+	if msgs[0].From != schema.AgentB {
+		t.Errorf("expected From=%q, got %q", schema.AgentB, msgs[0].From)
+	}
+}
+
+func TestRouting_HumanInbox(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.SetRouting(true, 1)
+
+	msg := schema.NewUserMessage(schema.AgentA, "hello")
+	msg.Hops = 5 // hop limit does not apply to the human inbox
+	b.SendMessage(msg)
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	inbox, _ := qMgr.GetQueue(schema.Human)
+	n, _ := inbox.Len()
+	if n != 1 {
+		t.Errorf("expected 1 message in human inbox, got %d", n)
+	}
+}
+
+func TestRouting_HopLimit(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+	b.SetRouting(true, 3)
+
+	b.Inject(schema.AgentA, schema.AgentB, "start talking")
+
+	// Bounce replies between agents until nothing is left to process
+	processed := 0
+	for i := 0; i < 10; i++ {
+		for _, agent := range b.Agents() {
+			resp, err := b.ProcessNext(context.Background(), agent)
+			if err != nil {
+				t.Fatalf("ProcessNext failed: %v", err)
+			}
+			if resp != nil {
+				processed++
+			}
+		}
+	}
+
+	// The injected message plus three routed replies
+	if processed != 4 {
+		t.Errorf("expected 4 turns before hop limit, got %d", processed)
+	}
+}
+
+func TestRouting_DisabledByDefault(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+
+	b.Inject(schema.AgentB, schema.AgentA, "no routing")
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	qB, _ := qMgr.GetQueue(schema.AgentB)
+	n, _ := qB.Len()
+	if n != 0 {
+		t.Errorf("expected no routed messages without routing, got %d", n)
+	}
+}
+
+// SlowExecutor takes a fixed time per call and tracks concurrency
+type SlowExecutor struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	running int
+	peak    int
+	seen    []string
+}
+
+func (s *SlowExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.peak {
+		s.peak = s.running
+	}
+	s.seen = append(s.seen, message)
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+
+	if isNew {
+		sessionID = "session-" + message
+	}
+	return &ExecuteResult{SessionID: sessionID, Response: "ok"}, nil
+}
+
+func (s *SlowExecutor) Peak() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+func runUntilResponses(t *testing.T, b *Broker, want int) []*schema.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var responses []*schema.Message
+	done := make(chan struct{})
+	b.SetResponseHandler(func(msg *schema.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		responses = append(responses, msg)
+		if len(responses) == want {
+			close(done)
+		}
+	})
+
+	go b.Run(ctx, 10*time.Millisecond)
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("timeout waiting for %d responses", want)
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return responses
+}
+
+func TestRun_AgentsProcessConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	executor := &SlowExecutor{delay: 100 * time.Millisecond}
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "a"))
+	b.SendMessage(schema.NewUserMessage(schema.AgentB, "b"))
+
+	runUntilResponses(t, b, 2)
+
+	if executor.Peak() != 2 {
+		t.Errorf("expected both agents to run at once, peak concurrency was %d", executor.Peak())
+	}
+}
+
+func TestRun_MaxConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	executor := &SlowExecutor{delay: 20 * time.Millisecond}
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	agents := []string{"agent-1", "agent-2", "agent-3"}
+	for _, agent := range agents {
+		b.InitializeAgent(agent)
+		b.SendMessage(schema.NewUserMessage(agent, agent))
+	}
+	b.SetMaxConcurrent(1)
+
+	runUntilResponses(t, b, len(agents))
+
+	if executor.Peak() != 1 {
+		t.Errorf("expected peak concurrency 1, got %d", executor.Peak())
+	}
+}
+
+func TestRun_PerAgentOrder(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	executor := &SlowExecutor{delay: 5 * time.Millisecond}
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	b.InitializeAgent(schema.AgentA)
+
+	for i := 0; i < 5; i++ {
+		b.SendMessage(schema.NewUserMessage(schema.AgentA, fmt.Sprintf("msg-%d", i)))
+		time.Sleep(time.Millisecond) // Ensure distinct timestamps
+	}
+
+	runUntilResponses(t, b, 5)
+
+	if executor.Peak() != 1 {
+		t.Errorf("turns for one agent must not overlap, peak was %d", executor.Peak())
+	}
+	for i, msg := range executor.seen {
+		if want := fmt.Sprintf("msg-%d", i); msg != want {
+			t.Errorf("turn %d: expected %q, got %q", i, want, msg)
+		}
+	}
+}
+
+func TestRun_WakesOnEnqueue(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+
+	responseCh := make(chan *schema.Message, 1)
+	b.SetResponseHandler(func(msg *schema.Message) {
+		responseCh <- msg
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Poll interval far longer than the test; only the wakeup can deliver
+	go b.Run(ctx, time.Hour)
+	time.Sleep(10 * time.Millisecond)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "wake up"))
+
+	select {
+	case <-responseCh:
+	case <-time.After(time.Second):
+		t.Fatal("broker did not wake on enqueue")
+	}
+}
+
+func TestHistoryRecorded(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	hist, _ := history.NewStore(dir + "/history")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.SetHistory(hist)
+	b.SetRouting(true, 0)
+
+	msg := schema.NewUserMessage(schema.AgentA, "remember me")
+	b.SendMessage(msg)
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	records, err := hist.Query(history.Filter{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	var events []string
+	for _, r := range records {
+		events = append(events, r.Event)
+	}
+	// The response is recorded once the reply is routed and the message acked
+	want := []string{history.EventEnqueued, history.EventStarted, history.EventProcessed, history.EventEnqueued, history.EventResponse}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+	if records[4].Message.InReplyTo != msg.ID {
+		t.Errorf("expected response to reference %q, got %q", msg.ID, records[4].Message.InReplyTo)
+	}
+}
+
+func TestHistory_NoResponseWhenRouteFails(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	hist, _ := history.NewStore(dir + "/history")
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.SetHistory(hist)
+	b.SetRouting(true, 0)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	sendFrom(b, schema.AgentB, "hello")
+	// Routing the reply fails because agent-b's queue can't be created
+	os.WriteFile(filepath.Join(dir, "queues", schema.AgentB), nil, 0644)
+
+	if _, err := b.ProcessNext(context.Background(), schema.AgentA); err == nil {
+		t.Fatal("expected error from failed route")
+	}
+	records, _ := hist.Query(history.Filter{})
+	for _, r := range records {
+		if r.Event == history.EventResponse {
+			t.Errorf("expected no response recorded for a message sent back for a retry, got %+v", r.Message)
+		}
+	}
+}
+
+// InspectingExecutor runs a check against broker state mid-turn
+type InspectingExecutor struct {
+	inspect func()
+}
+
+func (e *InspectingExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	e.inspect()
+	return &ExecuteResult{SessionID: "session-1", Response: "done"}, nil
+}
+
+func TestProcessNext_LeasesUntilAck(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	q, _ := qMgr.GetQueue(schema.AgentA)
+
+	inflightDuringTurn := 0
+	executor := &InspectingExecutor{inspect: func() {
+		leases, _ := q.InFlight()
+		inflightDuringTurn = len(leases)
+	}}
+
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	b.InitializeAgent(schema.AgentA)
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "hold me"))
+
+	if _, err := b.ProcessNext(context.Background(), schema.AgentA); err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+
+	if inflightDuringTurn != 1 {
+		t.Errorf("expected message in flight during turn, got %d", inflightDuringTurn)
+	}
+	leases, _ := q.InFlight()
+	if len(leases) != 0 {
+		t.Errorf("expected message acked after turn, %d still in flight", len(leases))
+	}
+}
+
+func TestProcessNext_RedeliversAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	q, _ := qMgr.GetQueue(schema.AgentA)
+
+	msg := schema.NewUserMessage(schema.AgentA, "survive")
+	q.Enqueue(msg)
+
+	// A previous broker leased the message and died before acking
+	q.Lease(-time.Second)
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.InitializeAgent(schema.AgentA)
+
+	resp, err := b.ProcessNext(context.Background(), schema.AgentA)
+	if err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	if resp == nil || resp.InReplyTo != msg.ID {
+		t.Fatal("expected expired lease to be redelivered and processed")
+	}
+}
+
+func TestInitializeAgent_ResumesLoadedSession(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+
+	// A previous broker run left a session behind
+	prev, _ := session.NewManager(dir + "/sessions")
+	prev.CreateSession(schema.AgentA)
+	prev.SetSessionID(schema.AgentA, "earlier-session")
+
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	sMgr.Load()
+
+	executor := &MockExecutor{}
+	b, _ := NewBroker(qMgr, sMgr, executor)
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "continue"))
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	if len(executor.calls) != 1 || executor.calls[0].IsNew {
+		t.Fatal("expected the loaded session to be resumed")
+	}
+	if executor.calls[0].SessionID != "earlier-session" {
+		t.Errorf("expected resume of earlier-session, got %q", executor.calls[0].SessionID)
+	}
+}
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRun_RegistryAddAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	reg, _ := registry.NewRegistry(dir)
+	reg.Add(schema.AgentA, registry.Profile{})
+
+	b, _ := NewBroker(qMgr, sMgr, &SlowExecutor{delay: time.Millisecond})
+	b.SetRegistry(reg)
+
+	responseCh := make(chan *schema.Message, 10)
+	b.SetResponseHandler(func(msg *schema.Message) { responseCh <- msg })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+
+	waitFor(t, "agent-a to start", func() bool { return slices.Contains(b.Agents(), schema.AgentA) })
+
+	// An agent added while the broker runs gets a worker
+	reg.Add("agent-c", registry.Profile{})
+	waitFor(t, "agent-c to start", func() bool { return slices.Contains(b.Agents(), "agent-c") })
+
+	b.SendMessage(schema.NewUserMessage("agent-c", "hello"))
+	select {
+	case msg := <-responseCh:
+		if msg.From != "agent-c" {
+			t.Errorf("expected response from agent-c, got %s", msg.From)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new agent did not process its queue")
+	}
+
+	reg.Remove(schema.AgentA)
+	waitFor(t, "agent-a to stop", func() bool { return !slices.Contains(b.Agents(), schema.AgentA) })
+}
+
+func TestRun_ReaddedAgentWaitsForOldWorker(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	reg, _ := registry.NewRegistry(dir)
+	reg.Add(schema.AgentA, registry.Profile{})
+
+	// The turn ignores cancellation, like a process slow to die
+	exec := &SlowExecutor{delay: 300 * time.Millisecond}
+	b, _ := NewBroker(qMgr, sMgr, exec)
+	b.SetRegistry(reg)
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "first"))
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "second"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+
+	waitFor(t, "the first turn", func() bool {
+		exec.mu.Lock()
+		defer exec.mu.Unlock()
+		return exec.running == 1
+	})
+	reg.Remove(schema.AgentA)
+	waitFor(t, "agent-a to stop", func() bool { return !slices.Contains(b.Agents(), schema.AgentA) })
+	reg.Add(schema.AgentA, registry.Profile{})
+	waitFor(t, "agent-a to restart", func() bool { return slices.Contains(b.Agents(), schema.AgentA) })
+
+	waitFor(t, "the second turn", func() bool {
+		exec.mu.Lock()
+		defer exec.mu.Unlock()
+		return len(exec.seen) >= 2
+	})
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if exec.peak != 1 {
+		t.Errorf("expected one turn at a time for agent-a, got %d at once", exec.peak)
+	}
+}
+
+func TestRun_DrainingAgentIsUnregisteredWhenEmpty(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	reg, _ := registry.NewRegistry(dir)
+	reg.Add(schema.AgentA, registry.Profile{})
+	reg.SetState(schema.AgentA, registry.StateDraining)
+
+	b, _ := NewBroker(qMgr, sMgr, &SlowExecutor{delay: time.Millisecond})
+	b.SetRegistry(reg)
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "last one"))
+
+	processed := make(chan struct{}, 1)
+	b.SetResponseHandler(func(msg *schema.Message) { processed <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+
+	select {
+	case <-processed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("draining agent did not finish its queue")
+	}
+
+	waitFor(t, "agent-a to be unregistered", func() bool {
+		_, err := reg.Get(schema.AgentA)
+		return err != nil
+	})
+	waitFor(t, "agent-a to stop", func() bool { return !slices.Contains(b.Agents(), schema.AgentA) })
+}
+
+// EchoModelExecutor answers with the model it was configured with
+type EchoModelExecutor struct {
+	model string
+}
+
+func (e *EchoModelExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	return &ExecuteResult{SessionID: "session-" + e.model, Response: e.model}, nil
+}
+
+func TestRun_ExecutorPerAgentProfile(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	reg, _ := registry.NewRegistry(dir)
+	reg.Add("reviewer", registry.Profile{Model: "opus"})
+	reg.Add("implementer", registry.Profile{Model: "sonnet"})
+
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.SetRegistry(reg)
+	b.SetExecutorFactory(func(a *registry.Agent) Executor {
+		return &EchoModelExecutor{model: a.Profile.Model}
+	})
+
+	b.SendMessage(schema.NewUserMessage("reviewer", "review"))
+	b.SendMessage(schema.NewUserMessage("implementer", "implement"))
+
+	responses := runUntilResponses(t, b, 2)
+
+	for _, resp := range responses {
+		want := map[string]string{"reviewer": "opus", "implementer": "sonnet"}[resp.From]
+		if resp.Payload.Text != want {
+			t.Errorf("%s: expected executor for model %q, got %q", resp.From, want, resp.Payload.Text)
+		}
+	}
+}
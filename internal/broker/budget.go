@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// SetCostLedger records the cost of every turn in l. Budgets are only
+// enforced with a ledger.
+func (b *Broker) SetCostLedger(l *cost.Ledger) {
+	b.ledger = l
+}
+
+// SetRunBudget stops Run once the run has spent usd. Zero means no limit.
+func (b *Broker) SetRunBudget(usd float64) {
+	b.runBudget = usd
+}
+
+// SetBudget pauses any agent once its accumulated spend reaches usd. Zero
+// means no limit.
+func (b *Broker) SetBudget(usd float64) {
+	b.budget = usd
+}
+
+// SetAgentBudget overrides the budget for one agent. Zero restores the
+// broker's default.
+func (b *Broker) SetAgentBudget(agentID string, usd float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if usd > 0 {
+		b.budgets[agentID] = usd
+	} else {
+		delete(b.budgets, agentID)
+	}
+}
+
+func (b *Broker) budgetFor(agentID string) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if usd, ok := b.budgets[agentID]; ok {
+		return usd
+	}
+	return b.budget
+}
+
+// startRun opens a new run in the ledger
+func (b *Broker) startRun() {
+	if b.ledger == nil {
+		return
+	}
+	if _, err := b.ledger.StartRun(b.runBudget, b.budget); err != nil && b.errorHandler != nil {
+		b.errorHandler("costs", err)
+	}
+}
+
+// spend records the cost of a turn and stops the run if it went over budget
+func (b *Broker) spend(agentID string, result *ExecuteResult) {
+	if b.ledger == nil || result == nil {
+		return
+	}
+	totals, err := b.ledger.Add(agentID, result.Cost)
+	if err != nil {
+		if b.errorHandler != nil {
+			b.errorHandler(agentID, err)
+		}
+		return
+	}
+
+	if b.runBudget <= 0 || totals.Run == nil || totals.Run.Spend.USD < b.runBudget {
+		return
+	}
+	b.mu.Lock()
+	stop := b.stopRun
+	b.stopRun = nil
+	b.mu.Unlock()
+	if stop != nil {
+		b.notify(fmt.Sprintf("Run budget reached: spent $%.4f of $%.2f. Stopping the broker.",
+			totals.Run.Spend.USD, b.runBudget))
+		stop()
+	}
+}
+
+// paused reports whether an agent has used up its budget. The first time it
+// has, a system message says so; raising the budget resumes the agent.
+func (b *Broker) paused(agentID string) bool {
+	budget := b.budgetFor(agentID)
+	if b.ledger == nil || budget <= 0 {
+		b.setPaused(agentID, false)
+		return false
+	}
+
+	totals, err := b.ledger.Read()
+	if err != nil {
+		if b.errorHandler != nil {
+			b.errorHandler(agentID, err)
+		}
+		return false
+	}
+
+	spent := totals.Agent(agentID).USD
+	over := spent >= budget
+	if over && b.setPaused(agentID, true) {
+		b.notify(fmt.Sprintf("%s paused: spent $%.4f of its $%.2f budget. Raise it with 'cc-bridge agent set %s --budget'.",
+			agentID, spent, budget, agentID))
+	}
+	if !over {
+		b.setPaused(agentID, false)
+	}
+	return over
+}
+
+// setPaused records whether an agent is paused and reports whether that
+// changed
+func (b *Broker) setPaused(agentID string, paused bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pausedAgents[agentID] == paused {
+		return false
+	}
+	if paused {
+		b.pausedAgents[agentID] = true
+	} else {
+		delete(b.pausedAgents, agentID)
+	}
+	return true
+}
+
+// notify delivers a system message to the human inbox
+func (b *Broker) notify(text string) {
+	msg := schema.NewMessage(schema.System, schema.Human, schema.TypeSystem, text)
+	if err := b.SendMessage(msg); err != nil && b.errorHandler != nil {
+		b.errorHandler(schema.System, fmt.Errorf("failed to send system message: %w", err))
+	}
+	if b.handler != nil {
+		b.handler(msg)
+	}
+}
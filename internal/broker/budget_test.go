@@ -0,0 +1,175 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/cost"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// CostlyExecutor charges a fixed cost per turn
+type CostlyExecutor struct {
+	cost  float64
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *CostlyExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	return &ExecuteResult{SessionID: "session-1", Response: "ok", Cost: c.cost}, nil
+}
+
+func (c *CostlyExecutor) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// newBudgetBroker adds a cost ledger to a test broker and collects the
+// system notices it sends
+func newBudgetBroker(t *testing.T, exec Executor) (*Broker, *queue.Manager, *cost.Ledger, *[]*schema.Message) {
+	t.Helper()
+	b, qMgr, dir := newTestBroker(t, exec, schema.AgentA, schema.AgentB)
+	ledger, _ := cost.NewLedger(dir)
+	b.SetCostLedger(ledger)
+
+	var mu sync.Mutex
+	var notices []*schema.Message
+	b.SetResponseHandler(func(msg *schema.Message) {
+		if msg.Type == schema.TypeSystem {
+			mu.Lock()
+			notices = append(notices, msg)
+			mu.Unlock()
+		}
+	})
+	return b, qMgr, ledger, &notices
+}
+
+func TestBudget_PausesAgent(t *testing.T) {
+	exec := &CostlyExecutor{cost: 0.4}
+	b, qMgr, ledger, notices := newBudgetBroker(t, exec)
+	b.SetBudget(10)
+	b.SetAgentBudget(schema.AgentA, 1)
+
+	for range 5 {
+		b.SendMessage(schema.NewUserMessage(schema.AgentA, "work"))
+	}
+	b.drain(context.Background(), schema.AgentA)
+
+	// 0.4 + 0.4 + 0.4 crosses the $1 budget; the rest stay queued
+	if exec.Calls() != 3 {
+		t.Errorf("expected 3 turns before pausing, got %d", exec.Calls())
+	}
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	if n, _ := q.Len(); n != 2 {
+		t.Errorf("expected 2 messages left queued, got %d", n)
+	}
+	totals, _ := ledger.Read()
+	if a := totals.Agent(schema.AgentA); a.Turns != 3 {
+		t.Errorf("expected 3 turns recorded, got %+v", a)
+	}
+
+	// The pause is announced once, in the human inbox too
+	b.drain(context.Background(), schema.AgentA)
+	if len(*notices) != 1 || !strings.Contains((*notices)[0].Payload.Text, "agent-a paused") {
+		t.Fatalf("expected one pause notice, got %+v", *notices)
+	}
+	inbox, _ := qMgr.GetQueue(schema.Human)
+	msgs, _ := inbox.List()
+	if len(msgs) != 1 || msgs[0].From != schema.System || msgs[0].Type != schema.TypeSystem {
+		t.Errorf("expected system message in human inbox, got %+v", msgs)
+	}
+
+	// Raising the budget resumes the agent
+	b.SetAgentBudget(schema.AgentA, 5)
+	b.drain(context.Background(), schema.AgentA)
+	if exec.Calls() != 5 {
+		t.Errorf("expected remaining messages processed, got %d calls", exec.Calls())
+	}
+}
+
+// OverrunExecutor fails every turn after spending cost, as a turn killed by
+// its timeout does
+type OverrunExecutor struct {
+	cost float64
+}
+
+func (o *OverrunExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	return &ExecuteResult{SessionID: "session-1", Cost: o.cost}, errors.New("turn timed out")
+}
+
+func TestBudget_FailedTurnsCount(t *testing.T) {
+	b, qMgr, ledger, _ := newBudgetBroker(t, &OverrunExecutor{cost: 0.6})
+	b.SetAgentBudget(schema.AgentA, 1)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	for range 3 {
+		b.SendMessage(schema.NewUserMessage(schema.AgentA, "work"))
+	}
+	for range 3 {
+		b.drain(context.Background(), schema.AgentA) // Stops at each failure
+	}
+
+	// Two failed turns at 0.6 cross the $1 budget and pause the agent
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	if n, _ := q.Len(); n != 1 {
+		t.Errorf("expected 1 message left queued, got %d", n)
+	}
+	totals, _ := ledger.Read()
+	if a := totals.Agent(schema.AgentA); a.Turns != 2 || a.USD < 1.2-1e-9 {
+		t.Errorf("expected 2 failed turns costing 1.2 recorded, got %+v", a)
+	}
+}
+
+func TestBudget_RunBudgetStopsBroker(t *testing.T) {
+	exec := &CostlyExecutor{cost: 1}
+	b, _, ledger, notices := newBudgetBroker(t, exec)
+	b.SetRunBudget(2)
+
+	for range 5 {
+		b.SendMessage(schema.NewUserMessage(schema.AgentA, "work"))
+		b.SendMessage(schema.NewUserMessage(schema.AgentB, "work"))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.Run(context.Background(), 10*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Run to return once the run budget was spent")
+	}
+
+	totals, _ := ledger.Read()
+	if totals.Run == nil || totals.Run.Spend.USD < 2 || totals.Run.Budget != 2 {
+		t.Errorf("unexpected run totals: %+v", totals.Run)
+	}
+	if len(*notices) != 1 || !strings.Contains((*notices)[0].Payload.Text, "Run budget reached") {
+		t.Errorf("expected a run budget notice, got %+v", *notices)
+	}
+}
+
+func TestBudget_NoLedgerNoLimit(t *testing.T) {
+	exec := &CostlyExecutor{cost: 1}
+	b, _, _ := newTestBroker(t, exec, schema.AgentA)
+	b.SetBudget(0.5)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "1"))
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "2"))
+	b.drain(context.Background(), schema.AgentA)
+
+	if exec.Calls() != 2 {
+		t.Errorf("expected budgets ignored without a ledger, got %d calls", exec.Calls())
+	}
+}
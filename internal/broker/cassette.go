@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
+)
+
+// ErrCassetteMismatch is returned by ReplayExecutor for a call the cassette
+// has no recording of
+var ErrCassetteMismatch = errors.New("no recorded interaction")
+
+// Cassette is a recording of executor calls and their outcomes
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded call. Error is set instead of Result when the
+// call failed.
+type Interaction struct {
+	SessionID string         `json:"session_id"`
+	Message   string         `json:"message"`
+	IsNew     bool           `json:"is_new"`
+	Result    *ExecuteResult `json:"result,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+func (i *Interaction) matches(sessionID, message string, isNew bool) bool {
+	return i.SessionID == sessionID && i.Message == message && i.IsNew == isNew
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette: %w", err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette atomically
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// RecordingExecutor passes calls through to another executor, usually a
+// ClaudeExecutor, and writes every exchange to a cassette file
+type RecordingExecutor struct {
+	exec     Executor
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingExecutor records exec's calls to a new cassette at path
+func NewRecordingExecutor(exec Executor, path string) *RecordingExecutor {
+	return &RecordingExecutor{exec: exec, path: path}
+}
+
+// Execute runs the wrapped executor and saves the exchange, including
+// failures, so a replay reproduces them
+func (r *RecordingExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	result, err := r.exec.Execute(ctx, sessionID, message, isNew)
+	if ctx.Err() != nil {
+		return result, err // Interrupted; the call is retried later
+	}
+
+	interaction := Interaction{SessionID: sessionID, Message: message, IsNew: isNew, Result: result}
+	if err != nil {
+		interaction.Result, interaction.Error = nil, err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if serr := r.cassette.Save(r.path); serr != nil {
+		return nil, errors.Join(serr, err)
+	}
+	return result, err
+}
+
+// ReplayExecutor serves the results of a cassette. Calls are matched on
+// session ID, message and isNew, and repeated calls get the recordings in
+// order, so agents running concurrently may interleave differently than
+// when recorded.
+type ReplayExecutor struct {
+	mu         sync.Mutex
+	cassette   *Cassette
+	used       []bool
+	mismatches []string
+}
+
+// NewReplayExecutor replays the cassette at path
+func NewReplayExecutor(path string) (*ReplayExecutor, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayExecutor{cassette: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+// Execute returns the first unused recording of the call. A call that was
+// never recorded fails with ErrCassetteMismatch and is reported by Verify.
+func (r *ReplayExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.cassette.Interactions {
+		interaction := &r.cassette.Interactions[i]
+		if r.used[i] || !interaction.matches(sessionID, message, isNew) {
+			continue
+		}
+		r.used[i] = true
+		if interaction.Error != "" {
+			return nil, errors.New(interaction.Error)
+		}
+		result := *interaction.Result
+		return &result, nil
+	}
+
+	mismatch := fmt.Sprintf("session %q, is_new %v, message %q", sessionID, isNew, message)
+	r.mismatches = append(r.mismatches, mismatch)
+	return nil, fmt.Errorf("%w for %s", ErrCassetteMismatch, mismatch)
+}
+
+// Verify reports calls that had no recording and recordings that were never
+// replayed
+func (r *ReplayExecutor) Verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var problems []string
+	for _, m := range r.mismatches {
+		problems = append(problems, "unexpected call: "+m)
+	}
+	for i, used := range r.used {
+		if !used {
+			interaction := r.cassette.Interactions[i]
+			problems = append(problems, fmt.Sprintf("unused recording: session %q, is_new %v, message %q",
+				interaction.SessionID, interaction.IsNew, interaction.Message))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("cassette mismatch:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ctx := context.Background()
+
+	rec := NewRecordingExecutor(&FailingExecutor{failures: 1}, path)
+	if _, err := rec.Execute(ctx, "", "hello", true); err == nil {
+		t.Fatal("expected the wrapped executor's failure")
+	}
+	recorded, err := rec.Execute(ctx, "", "hello", true)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	rec.Execute(ctx, recorded.SessionID, "again", false)
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette failed: %v", err)
+	}
+	if len(c.Interactions) != 3 || c.Interactions[0].Error == "" || c.Interactions[1].Result == nil {
+		t.Fatalf("unexpected cassette: %+v", c.Interactions)
+	}
+
+	// Repeated calls replay in recorded order, failures included
+	replay, err := NewReplayExecutor(path)
+	if err != nil {
+		t.Fatalf("NewReplayExecutor failed: %v", err)
+	}
+	if _, err := replay.Execute(ctx, "", "hello", true); err == nil || !strings.Contains(err.Error(), "claude exploded") {
+		t.Errorf("expected recorded failure, got %v", err)
+	}
+	result, err := replay.Execute(ctx, "", "hello", true)
+	if err != nil || result.SessionID != recorded.SessionID || result.Response != recorded.Response {
+		t.Errorf("expected recorded result, got %+v, %v", result, err)
+	}
+
+	if err := replay.Verify(); err == nil || !strings.Contains(err.Error(), "unused recording") {
+		t.Errorf("expected unused recording reported, got %v", err)
+	}
+	replay.Execute(ctx, recorded.SessionID, "again", false)
+	if err := replay.Verify(); err != nil {
+		t.Errorf("expected cassette fully used, got %v", err)
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec := NewRecordingExecutor(&MockExecutor{}, path)
+	rec.Execute(context.Background(), "", "hello", true)
+
+	replay, _ := NewReplayExecutor(path)
+	_, err := replay.Execute(context.Background(), "", "goodbye", true)
+	if !errors.Is(err, ErrCassetteMismatch) {
+		t.Fatalf("expected ErrCassetteMismatch, got %v", err)
+	}
+	if err := replay.Verify(); err == nil || !strings.Contains(err.Error(), `unexpected call: session "", is_new true, message "goodbye"`) {
+		t.Errorf("expected unexpected call reported, got %v", err)
+	}
+}
+
+// The replay tests run the e2e scenarios offline against cassettes recorded
+// from claude. Re-record them with:
+//
+//	CC_BRIDGE_RECORD=1 go test -run TestE2E ./internal/broker
+
+func replayExecutor(t *testing.T, cassette string) *ReplayExecutor {
+	t.Helper()
+	exec, err := NewReplayExecutor(cassettePath(cassette))
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := exec.Verify(); err != nil {
+			t.Error(err)
+		}
+	})
+	return exec
+}
+
+func TestReplay_TwoAgentConversation(t *testing.T) {
+	runTwoAgentConversation(t, replayExecutor(t, "two_agent_conversation"))
+}
+
+func TestReplay_Injection(t *testing.T) {
+	runInjection(t, replayExecutor(t, "injection"))
+}
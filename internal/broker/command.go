@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
+)
+
+// CommandExecutor runs an arbitrary program for each turn, so agent CLIs
+// other than claude and local scripts can take part in the bridge
+type CommandExecutor struct {
+	Command   registry.Command
+	WorkDir   string
+	KillGrace time.Duration // Zero means DefaultKillGrace
+}
+
+// Default paths used for json output when Fields leaves a field unmapped
+var defaultCommandFields = map[string]string{
+	"response":   "$.response",
+	"session_id": "$.session_id",
+}
+
+// BuildArgs expands the placeholders in the command's argv
+func (e *CommandExecutor) BuildArgs(sessionID, message string) []string {
+	r := strings.NewReplacer("{{session_id}}", sessionID, "{{message}}", message)
+	args := make([]string, len(e.Command.Argv))
+	for i, arg := range e.Command.Argv {
+		args[i] = r.Replace(arg)
+	}
+	return args
+}
+
+// Execute runs the command once. A new conversation is given a fresh session
+// ID so programs that keep their own state can key it on {{session_id}}.
+func (e *CommandExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	if err := e.Command.Validate(); err != nil {
+		return nil, err
+	}
+	if isNew {
+		sessionID = uuid.New().String()
+	}
+
+	args := e.BuildArgs(sessionID, message)
+	var stdin io.Reader
+	if e.Command.Stdin {
+		stdin = strings.NewReader(message)
+	}
+
+	output, err := runCommand(ctx, args[0], args[1:], e.WorkDir, stdin, e.KillGrace)
+	if err != nil {
+		// Whatever the command printed before failing may still report a cost
+		if result, perr := e.ParseOutput(output, sessionID); perr == nil {
+			return result, err
+		}
+		return nil, err
+	}
+	return e.ParseOutput(output, sessionID)
+}
+
+// ParseOutput maps the command's stdout to a result. sessionID is kept
+// unless the output supplies one.
+func (e *CommandExecutor) ParseOutput(output []byte, sessionID string) (*ExecuteResult, error) {
+	result := &ExecuteResult{SessionID: sessionID}
+
+	if e.Command.Output != registry.OutputJSON {
+		result.Response = strings.TrimRight(string(output), "\n")
+		return result, nil
+	}
+
+	var doc any
+	if err := json.Unmarshal(output, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse command output: %w", err)
+	}
+
+	for _, field := range registry.CommandFields {
+		path, ok := e.Command.Fields[field]
+		if !ok {
+			if path, ok = defaultCommandFields[field]; !ok {
+				continue
+			}
+		}
+
+		value, found, err := lookupPath(doc, path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path for %s: %w", field, err)
+		}
+		if !found {
+			if field == "response" {
+				return nil, fmt.Errorf("command output has no %s at %s", field, path)
+			}
+			continue
+		}
+		if err := setResultField(result, field, value); err != nil {
+			return nil, fmt.Errorf("failed to map %s from %s: %w", field, path, err)
+		}
+	}
+	return result, nil
+}
+
+func setResultField(result *ExecuteResult, field string, value any) error {
+	switch field {
+	case "response":
+		result.Response = stringValue(value)
+	case "session_id":
+		if id := stringValue(value); id != "" {
+			result.SessionID = id
+		}
+	case "cost":
+		f, err := numberValue(value)
+		if err != nil {
+			return err
+		}
+		result.Cost = f
+	case "is_error":
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+		result.IsError = b
+		if b {
+			result.Subtype = "error"
+		}
+	case "input_tokens", "output_tokens":
+		f, err := numberValue(value)
+		if err != nil {
+			return err
+		}
+		if field == "input_tokens" {
+			result.Usage.InputTokens = int(f)
+		} else {
+			result.Usage.OutputTokens = int(f)
+		}
+	}
+	return nil
+}
+
+// stringValue renders a decoded JSON value as text. Strings are used as
+// is and anything else is re-encoded.
+func stringValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func numberValue(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("expected a number, got %T", value)
+}
+
+// lookupPath finds the value at a JSONPath-style path in a decoded JSON
+// document. Only child names and array indexes are supported, as in
+// "$.choices[0].message.content"; the leading "$" is optional.
+func lookupPath(doc any, path string) (any, bool, error) {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+
+	value := doc
+	for path != "" {
+		var key string
+		if strings.HasPrefix(path, "[") {
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, false, fmt.Errorf("unclosed [ in path")
+			}
+			key, path = path[:end+1], path[end+1:]
+		} else {
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			key, path = path[:end], path[end:]
+		}
+		path = strings.TrimPrefix(path, ".")
+
+		if strings.HasPrefix(key, "[") {
+			i, err := strconv.Atoi(key[1 : len(key)-1])
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid index %s", key)
+			}
+			items, ok := value.([]any)
+			if !ok || i < 0 || i >= len(items) {
+				return nil, false, nil
+			}
+			value = items[i]
+			continue
+		}
+
+		if key == "" {
+			return nil, false, fmt.Errorf("empty name in path")
+		}
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, false, nil
+		}
+		if value, ok = fields[key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return value, true, nil
+}
@@ -0,0 +1,201 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/registry"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+func TestCommandExecutorImplementsInterface(t *testing.T) {
+	var _ Executor = (*CommandExecutor)(nil)
+}
+
+func TestCommandExecutor_BuildArgs(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{
+		Argv: []string{"bot", "--session={{session_id}}", "{{message}}"},
+	}}
+
+	args := exec.BuildArgs("s-1", "hello world")
+	want := []string{"bot", "--session=s-1", "hello world"}
+	if len(args) != len(want) {
+		t.Fatalf("expected %v, got %v", want, args)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("arg %d: expected %q, got %q", i, want[i], args[i])
+		}
+	}
+}
+
+func TestCommandExecutor_ParseOutput_Text(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{Argv: []string{"bot"}}}
+
+	result, err := exec.ParseOutput([]byte("hi there\n"), "s-1")
+	if err != nil {
+		t.Fatalf("ParseOutput failed: %v", err)
+	}
+	if result.Response != "hi there" || result.SessionID != "s-1" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestCommandExecutor_ParseOutput_JSON(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{
+		Argv:   []string{"bot"},
+		Output: registry.OutputJSON,
+		Fields: map[string]string{
+			"response":      "$.choices[0].message.content",
+			"session_id":    "$.id",
+			"cost":          "$.usage.cost",
+			"output_tokens": "usage.completion_tokens",
+		},
+	}}
+
+	output := `{"id":"conv-9","choices":[{"message":{"content":"Hello!"}}],"usage":{"cost":0.25,"completion_tokens":12}}`
+	result, err := exec.ParseOutput([]byte(output), "s-1")
+	if err != nil {
+		t.Fatalf("ParseOutput failed: %v", err)
+	}
+	if result.Response != "Hello!" || result.SessionID != "conv-9" {
+		t.Errorf("unexpected response or session: %+v", result)
+	}
+	if result.Cost != 0.25 || result.Usage.OutputTokens != 12 {
+		t.Errorf("unexpected cost or usage: %+v", result)
+	}
+}
+
+func TestCommandExecutor_ParseOutput_JSONDefaults(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{Argv: []string{"bot"}, Output: registry.OutputJSON}}
+
+	result, err := exec.ParseOutput([]byte(`{"response":"ok"}`), "s-1")
+	if err != nil {
+		t.Fatalf("ParseOutput failed: %v", err)
+	}
+	if result.Response != "ok" || result.SessionID != "s-1" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if _, err := exec.ParseOutput([]byte(`{"reply":"ok"}`), "s-1"); err == nil {
+		t.Error("expected error when the response is missing")
+	}
+}
+
+func TestCommandExecutor_ParseOutput_Error(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{
+		Argv:   []string{"bot"},
+		Output: registry.OutputJSON,
+		Fields: map[string]string{"response": "$.message", "is_error": "$.failed"},
+	}}
+
+	result, err := exec.ParseOutput([]byte(`{"message":"quota exceeded","failed":true}`), "s-1")
+	if err != nil {
+		t.Fatalf("ParseOutput failed: %v", err)
+	}
+	if !result.IsError || result.Response != "quota exceeded" {
+		t.Errorf("expected error result, got %+v", result)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	doc := map[string]any{
+		"a": map[string]any{"b": []any{"zero", map[string]any{"c": 3.0}}},
+	}
+
+	tests := []struct {
+		path  string
+		want  any
+		found bool
+	}{
+		{"$.a.b[0]", "zero", true},
+		{"a.b[1].c", 3.0, true},
+		{"$.a.b[2]", nil, false},
+		{"$.a.missing", nil, false},
+		{"$.a.b.c", nil, false},
+	}
+	for _, tt := range tests {
+		got, found, err := lookupPath(doc, tt.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.path, err)
+			continue
+		}
+		if found != tt.found || got != tt.want {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", tt.path, tt.want, tt.found, got, found)
+		}
+	}
+
+	for _, path := range []string{"$.a[x]", "$.a[0", "$.a..b"} {
+		if _, _, err := lookupPath(doc, path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestCommandExecutor_Execute(t *testing.T) {
+	exec := &CommandExecutor{Command: registry.Command{
+		Argv:  []string{"sh", "-c", `printf '%s|%s|' "$0" "$1"; cat`, "{{session_id}}", "{{message}}"},
+		Stdin: true,
+	}}
+
+	result, err := exec.Execute(context.Background(), "s-1", "hello", false)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Response != "s-1|hello|hello" {
+		t.Errorf("expected session, message and stdin echoed, got %q", result.Response)
+	}
+
+	// A new conversation gets a fresh session ID
+	result, _ = exec.Execute(context.Background(), "", "hi", true)
+	if result.SessionID == "" {
+		t.Error("expected a session ID for a new conversation")
+	}
+
+	failing := &CommandExecutor{Command: registry.Command{Argv: []string{"sh", "-c", "echo nope >&2; exit 3"}}}
+	if _, err := failing.Execute(context.Background(), "s-1", "hi", false); err == nil {
+		t.Error("expected error from failing command")
+	}
+
+	// A command that fails after reporting its cost still returns it
+	partial := &CommandExecutor{Command: registry.Command{
+		Argv:   []string{"sh", "-c", `echo '{"response":"half","cost":0.5}'; exit 1`},
+		Output: registry.OutputJSON,
+		Fields: map[string]string{"cost": "$.cost"},
+	}}
+	result, err = partial.Execute(context.Background(), "s-1", "hi", false)
+	if err == nil || result == nil || result.Cost != 0.5 {
+		t.Errorf("expected the error with a result costing 0.5, got %+v, %v", result, err)
+	}
+}
+
+func TestCommandExecutor_Conversation(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	exec := &CommandExecutor{Command: registry.Command{
+		Argv: []string{"sh", "-c", `echo "[$0] $1"`, "{{session_id}}", "{{message}}"},
+	}}
+	b, _ := NewBroker(qMgr, sMgr, exec)
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "one"))
+	first, err := b.ProcessNext(context.Background(), schema.AgentA)
+	if err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "two"))
+	second, _ := b.ProcessNext(context.Background(), schema.AgentA)
+
+	sess, _ := sMgr.GetSession(schema.AgentA)
+	if want := "[" + sess.SessionID + "] two"; second.Payload.Text != want {
+		t.Errorf("expected %q, got %q", want, second.Payload.Text)
+	}
+	if first.Context.SessionID != second.Context.SessionID {
+		t.Errorf("expected the session to carry over, got %q and %q", first.Context.SessionID, second.Context.SessionID)
+	}
+}
@@ -2,7 +2,9 @@ package broker
 
 import (
 	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -12,9 +14,15 @@ import (
 	"github.com/binaryphile/cc-bridge/internal/session"
 )
 
-// TestE2E_TwoAgentConversation tests a full conversation between two agents.
-// Agent A receives a secret, then Agent B asks for it.
-func TestE2E_TwoAgentConversation(t *testing.T) {
+// recordEnv names the environment variable that makes the e2e tests record
+// their claude calls to the cassettes the replay tests use
+const recordEnv = "CC_BRIDGE_RECORD"
+
+// e2eExecutor returns the executor for an e2e test, skipping the test if
+// claude is unavailable. With CC_BRIDGE_RECORD=1 the calls are recorded to
+// testdata/<cassette>.json.
+func e2eExecutor(t *testing.T, cassette string) Executor {
+	t.Helper()
 	if testing.Short() {
 		t.Skip("skipping e2e test in short mode")
 	}
@@ -24,11 +32,27 @@ func TestE2E_TwoAgentConversation(t *testing.T) {
 		t.Skip("claude binary not found, skipping e2e test")
 	}
 
+	if os.Getenv(recordEnv) == "" {
+		return NewClaudeExecutor()
+	}
+	return NewRecordingExecutor(NewClaudeExecutor(), cassettePath(cassette))
+}
+
+func cassettePath(name string) string {
+	return filepath.Join("testdata", name+".json")
+}
+
+// TestE2E_TwoAgentConversation tests a full conversation between two agents.
+// Agent A receives a secret, then Agent B asks for it.
+func TestE2E_TwoAgentConversation(t *testing.T) {
+	runTwoAgentConversation(t, e2eExecutor(t, "two_agent_conversation"))
+}
+
+func runTwoAgentConversation(t *testing.T, executor Executor) {
 	dir := t.TempDir()
 	qMgr, _ := queue.NewManager(dir + "/queues")
 	sMgr, _ := session.NewManager(dir + "/sessions")
 
-	executor := NewClaudeExecutor()
 	b, _ := NewBroker(qMgr, sMgr, executor)
 
 	// Initialize both agents
@@ -121,19 +145,14 @@ func TestE2E_TwoAgentConversation(t *testing.T) {
 
 // TestE2E_Injection tests that injection correctly routes messages
 func TestE2E_Injection(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping e2e test in short mode")
-	}
-
-	if _, err := exec.LookPath("claude"); err != nil {
-		t.Skip("claude binary not found, skipping e2e test")
-	}
+	runInjection(t, e2eExecutor(t, "injection"))
+}
 
+func runInjection(t *testing.T, executor Executor) {
 	dir := t.TempDir()
 	qMgr, _ := queue.NewManager(dir + "/queues")
 	sMgr, _ := session.NewManager(dir + "/sessions")
 
-	executor := NewClaudeExecutor()
 	b, _ := NewBroker(qMgr, sMgr, executor)
 
 	b.InitializeAgent(schema.AgentA)
@@ -5,22 +5,65 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
 )
 
 // ClaudeExecutor executes Claude CLI commands
-type ClaudeExecutor struct{}
+type ClaudeExecutor struct {
+	// Stream requests stream-json output so the result carries every
+	// intermediate event, such as tool calls, not just the final text
+	Stream bool
+
+	// Profile sets the model, prompts, tool permissions, turn limit and
+	// working directory for every invocation
+	Profile registry.Profile
+
+	// KillGrace is how long claude and the tools it started get to exit
+	// after SIGTERM when a turn is cancelled; zero means DefaultKillGrace
+	KillGrace time.Duration
+
+	// ForkSession adds --fork-session to resumed turns, so claude continues
+	// the conversation under a new session ID and the original is untouched
+	ForkSession bool
+}
 
 // NewClaudeExecutor creates a new ClaudeExecutor
 func NewClaudeExecutor() *ClaudeExecutor {
 	return &ClaudeExecutor{}
 }
 
-// claudeOutput represents the JSON output from claude CLI
+// claudeOutput represents the JSON output from claude CLI. The final line
+// of stream-json output has the same shape.
 type claudeOutput struct {
-	SessionID string  `json:"session_id"`
-	Result    string  `json:"result"`
-	Cost      float64 `json:"total_cost_usd"`
+	Type          string  `json:"type"`
+	Subtype       string  `json:"subtype"`
+	IsError       bool    `json:"is_error"`
+	SessionID     string  `json:"session_id"`
+	Result        string  `json:"result"`
+	Cost          float64 `json:"total_cost_usd"`
+	DurationMS    int64   `json:"duration_ms"`
+	DurationAPIMS int64   `json:"duration_api_ms"`
+	NumTurns      int     `json:"num_turns"`
+	Usage         Usage   `json:"usage"`
+}
+
+func (co *claudeOutput) toResult() *ExecuteResult {
+	return &ExecuteResult{
+		SessionID:   co.SessionID,
+		Response:    co.Result,
+		Cost:        co.Cost,
+		Usage:       co.Usage,
+		Duration:    time.Duration(co.DurationMS) * time.Millisecond,
+		APIDuration: time.Duration(co.DurationAPIMS) * time.Millisecond,
+		NumTurns:    co.NumTurns,
+		IsError:     co.IsError,
+		Subtype:     co.Subtype,
+	}
 }
 
 // BuildArgs builds the command line arguments for claude
@@ -30,10 +73,73 @@ func (e *ClaudeExecutor) BuildArgs(sessionID, message string, isNew bool) []stri
 	if isNew {
 		args = append(args, "-p", message)
 	} else {
-		args = append(args, "--resume", sessionID, "-p", message)
+		args = append(args, e.resumeArgs(sessionID)...)
+		args = append(args, "-p", message)
 	}
 
-	args = append(args, "--output-format", "json", "--max-turns", "1")
+	if e.Stream {
+		// stream-json output is rejected without --verbose
+		args = append(args, "--output-format", "stream-json", "--verbose")
+	} else {
+		args = append(args, "--output-format", "json")
+	}
+	return append(args, e.profileArgs()...)
+}
+
+// BuildBatchArgs builds the arguments for a turn whose input is read from
+// stdin as stream-json, which claude only accepts with stream-json output
+func (e *ClaudeExecutor) BuildBatchArgs(sessionID string, isNew bool) []string {
+	args := []string{}
+
+	if !isNew {
+		args = append(args, e.resumeArgs(sessionID)...)
+	}
+	args = append(args, "-p", "--input-format", "stream-json", "--output-format", "stream-json", "--verbose")
+	return append(args, e.profileArgs()...)
+}
+
+func (e *ClaudeExecutor) resumeArgs(sessionID string) []string {
+	if e.ForkSession {
+		return []string{"--resume", sessionID, "--fork-session"}
+	}
+	return []string{"--resume", sessionID}
+}
+
+// Forking returns a copy of the executor that branches resumed sessions
+func (e *ClaudeExecutor) Forking() Executor {
+	fork := *e
+	fork.ForkSession = true
+	return &fork
+}
+
+// profileArgs converts the executor's profile to claude flags
+func (e *ClaudeExecutor) profileArgs() []string {
+	var args []string
+	p := e.Profile
+	if p.Model != "" {
+		args = append(args, "--model", p.Model)
+	}
+	if p.SystemPrompt != "" {
+		args = append(args, "--system-prompt", p.SystemPrompt)
+	}
+	if p.AppendSystemPrompt != "" {
+		args = append(args, "--append-system-prompt", p.AppendSystemPrompt)
+	}
+	if len(p.AllowedTools) > 0 {
+		args = append(args, "--allowedTools", strings.Join(p.AllowedTools, ","))
+	}
+	if len(p.DisallowedTools) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(p.DisallowedTools, ","))
+	}
+	if p.PermissionMode != "" {
+		args = append(args, "--permission-mode", p.PermissionMode)
+	}
+
+	maxTurns := 1
+	if p.MaxTurns > 0 {
+		maxTurns = p.MaxTurns
+	}
+	args = append(args, "--max-turns", strconv.Itoa(maxTurns))
 	return args
 }
 
@@ -44,26 +150,58 @@ func (e *ClaudeExecutor) ParseResult(output []byte) (*ExecuteResult, error) {
 		return nil, fmt.Errorf("failed to parse claude output: %w", err)
 	}
 
-	return &ExecuteResult{
-		SessionID: co.SessionID,
-		Response:  co.Result,
-		Cost:      co.Cost,
-	}, nil
+	return co.toResult(), nil
 }
 
 // Execute runs the claude CLI and returns the result
 func (e *ClaudeExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
-	args := e.BuildArgs(sessionID, message, isNew)
+	output, runErr := e.run(ctx, e.BuildArgs(sessionID, message, isNew), nil)
+
+	var result *ExecuteResult
+	var err error
+	if e.Stream {
+		result, err = ParseStream(output)
+	} else {
+		result, err = e.ParseResult(output)
+	}
+	return resultOrRunError(result, err, runErr)
+}
 
-	cmd := exec.CommandContext(ctx, "claude", args...)
+// ExecuteBatch sends all messages to claude in a single turn. They arrive as
+// one user message with a content block per message, each naming its sender.
+func (e *ClaudeExecutor) ExecuteBatch(ctx context.Context, sessionID string, messages []BatchMessage, isNew bool) (*ExecuteResult, error) {
+	input, err := BatchInput(messages)
+	if err != nil {
+		return nil, err
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	output, runErr := e.run(ctx, e.BuildBatchArgs(sessionID, isNew), bytes.NewReader(input))
+	result, err := ParseStream(output)
+	return resultOrRunError(result, err, runErr)
+}
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("claude command failed: %w, stderr: %s", err, stderr.String())
+// resultOrRunError picks what to report for a claude run. claude may exit
+// non-zero while still printing an error result, which carries more detail
+// than the exit status, so a parsed error result wins over runErr. Any other
+// parsed result is returned with runErr so its cost is still counted.
+func resultOrRunError(result *ExecuteResult, parseErr, runErr error) (*ExecuteResult, error) {
+	if runErr != nil {
+		if parseErr != nil {
+			return nil, runErr
+		}
+		if result.IsError {
+			return result, nil
+		}
+		return result, runErr
 	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return result, nil
+}
 
-	return e.ParseResult(stdout.Bytes())
+// run executes claude with args and returns its stdout, which is returned
+// even if claude fails
+func (e *ClaudeExecutor) run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	return runCommand(ctx, "claude", args, e.Profile.WorkDir, stdin, e.KillGrace)
 }
@@ -2,14 +2,20 @@ package broker
 
 import (
 	"context"
+	"errors"
 	"os/exec"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/registry"
 )
 
 func TestClaudeExecutorImplementsInterface(t *testing.T) {
 	// Compile-time check that ClaudeExecutor implements Executor
 	var _ Executor = (*ClaudeExecutor)(nil)
+	var _ BatchExecutor = (*ClaudeExecutor)(nil)
 }
 
 func TestNewClaudeExecutor(t *testing.T) {
@@ -86,6 +92,66 @@ func TestClaudeExecutor_BuildCommand_Resume(t *testing.T) {
 	}
 }
 
+func TestClaudeExecutor_BuildCommand_Stream(t *testing.T) {
+	exec := &ClaudeExecutor{Stream: true}
+
+	args := strings.Join(exec.BuildArgs("", "hello", true), " ")
+
+	if !strings.Contains(args, "--output-format stream-json --verbose") {
+		t.Errorf("expected stream-json output with --verbose, got %q", args)
+	}
+	if !strings.Contains(args, "--max-turns 1") {
+		t.Errorf("expected --max-turns 1, got %q", args)
+	}
+}
+
+func TestClaudeExecutor_BuildCommand_Profile(t *testing.T) {
+	exec := &ClaudeExecutor{Profile: registry.Profile{
+		Model:              "opus",
+		SystemPrompt:       "You review code.",
+		AppendSystemPrompt: "Be brief.",
+		AllowedTools:       []string{"Read", "Grep"},
+		DisallowedTools:    []string{"Bash"},
+		PermissionMode:     "plan",
+		MaxTurns:           5,
+	}}
+
+	args := exec.BuildArgs("session-123", "review this", false)
+
+	want := map[string]string{
+		"--model":                "opus",
+		"--system-prompt":        "You review code.",
+		"--append-system-prompt": "Be brief.",
+		"--allowedTools":         "Read,Grep",
+		"--disallowedTools":      "Bash",
+		"--permission-mode":      "plan",
+		"--max-turns":            "5",
+	}
+	for flag, value := range want {
+		i := slices.Index(args, flag)
+		if i < 0 || i+1 >= len(args) || args[i+1] != value {
+			t.Errorf("expected %s %q in %q", flag, value, args)
+		}
+	}
+}
+
+func TestClaudeExecutor_BuildBatchArgs(t *testing.T) {
+	exec := &ClaudeExecutor{Profile: registry.Profile{Model: "opus"}}
+
+	args := strings.Join(exec.BuildBatchArgs("session-123", false), " ")
+
+	for _, want := range []string{
+		"--resume session-123 -p",
+		"--input-format stream-json",
+		"--output-format stream-json --verbose",
+		"--model opus",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %q", want, args)
+		}
+	}
+}
+
 func TestClaudeExecutor_ParseResult(t *testing.T) {
 	exec := NewClaudeExecutor()
 
@@ -107,6 +173,61 @@ func TestClaudeExecutor_ParseResult(t *testing.T) {
 	}
 }
 
+func TestClaudeExecutor_ParseResult_Details(t *testing.T) {
+	exec := NewClaudeExecutor()
+
+	jsonOutput := `{"type":"result","subtype":"success","is_error":false,"duration_ms":2500,"duration_api_ms":2100,` +
+		`"num_turns":3,"result":"Done.","session_id":"abc-123","total_cost_usd":0.01,` +
+		`"usage":{"input_tokens":10,"output_tokens":250,"cache_creation_input_tokens":1200,"cache_read_input_tokens":4800}}`
+
+	result, err := exec.ParseResult([]byte(jsonOutput))
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+
+	want := Usage{InputTokens: 10, OutputTokens: 250, CacheCreationInputTokens: 1200, CacheReadInputTokens: 4800}
+	if result.Usage != want {
+		t.Errorf("expected usage %+v, got %+v", want, result.Usage)
+	}
+	if result.Duration != 2500*time.Millisecond || result.APIDuration != 2100*time.Millisecond {
+		t.Errorf("unexpected durations: %v, %v", result.Duration, result.APIDuration)
+	}
+	if result.NumTurns != 3 || result.Subtype != "success" || result.IsError {
+		t.Errorf("unexpected turn details: %+v", result)
+	}
+}
+
+func TestClaudeExecutor_ParseResult_Error(t *testing.T) {
+	exec := NewClaudeExecutor()
+
+	jsonOutput := `{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":2,"session_id":"abc-123"}`
+
+	result, err := exec.ParseResult([]byte(jsonOutput))
+	if err != nil {
+		t.Fatalf("ParseResult failed: %v", err)
+	}
+	if !result.IsError || result.Subtype != "error_max_turns" {
+		t.Errorf("expected error_max_turns error result, got %+v", result)
+	}
+}
+
+func TestResultOrRunError(t *testing.T) {
+	runErr := errors.New("exit status 1")
+
+	errResult := &ExecuteResult{IsError: true, Subtype: "error_during_execution"}
+	if result, err := resultOrRunError(errResult, nil, runErr); err != nil || result != errResult {
+		t.Errorf("expected the error result to win over the exit status, got %v, %v", result, err)
+	}
+
+	okResult := &ExecuteResult{Response: "partial"}
+	if result, err := resultOrRunError(okResult, nil, runErr); err != runErr || result != okResult {
+		t.Errorf("expected run error and the parsed result for a failed run without an error result, got %v, %v", result, err)
+	}
+	if _, err := resultOrRunError(nil, errors.New("bad json"), runErr); err != runErr {
+		t.Errorf("expected run error when output is unparseable, got %v", err)
+	}
+}
+
 func TestClaudeExecutor_ParseResult_InvalidJSON(t *testing.T) {
 	exec := NewClaudeExecutor()
 
@@ -143,3 +264,30 @@ func TestClaudeExecutor_Integration(t *testing.T) {
 		t.Error("expected non-empty Response")
 	}
 }
+
+// Integration test - only run if claude binary is available
+func TestClaudeExecutor_StreamIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	if _, err := exec.LookPath("claude"); err != nil {
+		t.Skip("claude binary not found, skipping integration test")
+	}
+
+	executor := &ClaudeExecutor{Stream: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := executor.Execute(ctx, "", "Say only: PONG", true)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if result.SessionID == "" {
+		t.Error("expected non-empty SessionID")
+	}
+	if len(result.Events) == 0 || result.Events[0].Type != EventSystem {
+		t.Errorf("expected the stream to start with a system event, got %+v", result.Events)
+	}
+}
@@ -0,0 +1,28 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// ForkExecutor is implemented by executors that can branch a conversation
+type ForkExecutor interface {
+	// Forking returns an executor whose resumed turns continue a session
+	// under a new session ID, leaving the original untouched
+	Forking() Executor
+}
+
+// turnExecutor returns the executor for the agent's next turn. While a
+// forked session has not yet branched, that is the forking variant.
+func (b *Broker) turnExecutor(agentID string, sess *session.Session) (Executor, error) {
+	exec := b.executorFor(agentID)
+	if !sess.PendingFork || sess.SessionID == "" {
+		return exec, nil
+	}
+	fork, ok := exec.(ForkExecutor)
+	if !ok {
+		return nil, fmt.Errorf("executor for %s cannot fork sessions", agentID)
+	}
+	return fork.Forking(), nil
+}
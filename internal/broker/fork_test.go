@@ -0,0 +1,146 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+func TestClaudeExecutor_Forking(t *testing.T) {
+	exec := NewClaudeExecutor()
+	fork := exec.Forking().(*ClaudeExecutor)
+
+	if args := strings.Join(fork.BuildArgs("session-123", "hi", false), " "); !strings.Contains(args, "--resume session-123 --fork-session -p hi") {
+		t.Errorf("expected --fork-session when resuming, got %q", args)
+	}
+	if args := strings.Join(fork.BuildBatchArgs("session-123", false), " "); !strings.Contains(args, "--resume session-123 --fork-session") {
+		t.Errorf("expected --fork-session for batches, got %q", args)
+	}
+	if args := strings.Join(fork.BuildArgs("", "hi", true), " "); strings.Contains(args, "--fork-session") {
+		t.Errorf("expected no --fork-session for a new session, got %q", args)
+	}
+	if exec.ForkSession {
+		t.Error("expected Forking to leave the original executor unchanged")
+	}
+}
+
+// ForkingMockExecutor records calls, giving forked turns a new session ID
+type ForkingMockExecutor struct {
+	fork  bool
+	calls *[]ExecuteCall
+	forks *int
+}
+
+func (m *ForkingMockExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	*m.calls = append(*m.calls, ExecuteCall{sessionID, message, isNew})
+	switch {
+	case isNew:
+		sessionID = "session-1"
+	case m.fork:
+		*m.forks++
+		sessionID = "branch-1"
+	}
+	return &ExecuteResult{SessionID: sessionID, Response: "reply to " + message}, nil
+}
+
+func (m *ForkingMockExecutor) Forking() Executor {
+	return &ForkingMockExecutor{fork: true, calls: m.calls, forks: m.forks}
+}
+
+func TestBroker_ForkedAgentBranches(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	var calls []ExecuteCall
+	var forks int
+	b, _ := NewBroker(qMgr, sMgr, &ForkingMockExecutor{calls: &calls, forks: &forks})
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "first"))
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	if _, err := sMgr.Fork(schema.AgentA, "agent-a2"); err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	b.InitializeAgent("agent-a2")
+
+	// Both agents answer a different follow-up from the same point
+	b.SendMessage(schema.NewUserMessage("agent-a2", "option two"))
+	if _, err := b.ProcessNext(context.Background(), "agent-a2"); err != nil {
+		t.Fatalf("forked turn failed: %v", err)
+	}
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "option one"))
+	b.ProcessNext(context.Background(), schema.AgentA)
+	b.SendMessage(schema.NewUserMessage("agent-a2", "more"))
+	b.ProcessNext(context.Background(), "agent-a2")
+
+	if forks != 1 {
+		t.Errorf("expected only the first forked turn to fork, got %d", forks)
+	}
+	if calls[1].SessionID != "session-1" || calls[1].IsNew {
+		t.Errorf("expected the fork to resume the original session, got %+v", calls[1])
+	}
+	if calls[3].SessionID != "branch-1" {
+		t.Errorf("expected later turns to resume the branch, got %+v", calls[3])
+	}
+
+	orig, _ := sMgr.GetSession(schema.AgentA)
+	branch, _ := sMgr.GetSession("agent-a2")
+	if orig.SessionID != "session-1" || orig.TurnNumber != 2 {
+		t.Errorf("expected original session untouched, got %+v", orig)
+	}
+	if branch.SessionID != "branch-1" || branch.PendingFork || branch.TurnNumber != 3 {
+		t.Errorf("unexpected branch session: %+v", branch)
+	}
+}
+
+func TestBroker_ForkUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	b.SetRetryPolicy(RetryPolicy{})
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "first"))
+	b.ProcessNext(context.Background(), schema.AgentA)
+	sMgr.Fork(schema.AgentA, "agent-a2")
+	b.InitializeAgent("agent-a2")
+
+	// Resuming the shared session instead would change the original
+	b.SendMessage(schema.NewUserMessage("agent-a2", "branch"))
+	_, err := b.ProcessNext(context.Background(), "agent-a2")
+	if err == nil || !strings.Contains(err.Error(), "cannot fork sessions") {
+		t.Errorf("expected fork failure, got %v", err)
+	}
+}
+
+func TestHTTPExecutor_Fork(t *testing.T) {
+	exec, fake, sMgr := newHTTPExecutor(t)
+
+	first, _ := exec.Execute(context.Background(), "", "hello", true)
+	branch, err := exec.Forking().Execute(context.Background(), first.SessionID, "branch", false)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if branch.SessionID == first.SessionID || !strings.HasPrefix(branch.SessionID, "http-") {
+		t.Errorf("expected a new session ID, got %q", branch.SessionID)
+	}
+	if branch.Response != "3 messages, last: branch" {
+		t.Errorf("expected the branch to carry the earlier turn, got %q", branch.Response)
+	}
+
+	original, _ := sMgr.Transcript(first.SessionID)
+	forked, _ := sMgr.Transcript(branch.SessionID)
+	if len(original) != 2 || len(forked) != 4 || forked[0].Content != "hello" {
+		t.Errorf("expected original kept and branch copied, got %d and %d messages", len(original), len(forked))
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("expected 2 requests, got %d", len(fake.requests))
+	}
+}
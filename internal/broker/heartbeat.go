@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
+)
+
+// HeartbeatInterval is how often Run refreshes the heartbeat file
+const HeartbeatInterval = 5 * time.Second
+
+// Heartbeat is what a running broker writes to its heartbeat file so other
+// processes can tell whether it is alive
+type Heartbeat struct {
+	PID       int           `json:"pid"`
+	StartedAt time.Time     `json:"started_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Interval  time.Duration `json:"interval"`
+}
+
+// Alive reports whether the broker has refreshed its heartbeat recently. A
+// broker that misses three refreshes is presumed dead.
+func (h *Heartbeat) Alive(now time.Time) bool {
+	return now.Sub(h.UpdatedAt) < 3*h.Interval
+}
+
+// ReadHeartbeat reads a heartbeat file. It returns an error satisfying
+// os.IsNotExist if no broker is running or the last one shut down cleanly.
+func ReadHeartbeat(path string) (*Heartbeat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var h Heartbeat
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal heartbeat: %w", err)
+	}
+	return &h, nil
+}
+
+// SetHeartbeatFile makes Run write a Heartbeat to path every
+// HeartbeatInterval and remove it when Run returns
+func (b *Broker) SetHeartbeatFile(path string) {
+	b.heartbeatPath = path
+}
+
+// heartbeat keeps the heartbeat file fresh until ctx is done, then removes it
+func (b *Broker) heartbeat(ctx context.Context) {
+	h := &Heartbeat{PID: os.Getpid(), StartedAt: time.Now().UTC(), Interval: HeartbeatInterval}
+	write := func() {
+		h.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(h)
+		if err == nil {
+			err = fsutil.WriteFileAtomic(b.heartbeatPath, data, 0644)
+		}
+		if err != nil && b.errorHandler != nil {
+			b.errorHandler("heartbeat", fmt.Errorf("failed to write heartbeat: %w", err))
+		}
+	}
+
+	write()
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			os.Remove(b.heartbeatPath)
+			return
+		case <-ticker.C:
+			write()
+		}
+	}
+}
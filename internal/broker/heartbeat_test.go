@@ -0,0 +1,46 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+func TestRun_Heartbeat(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	b, _ := NewBroker(qMgr, sMgr, &MockExecutor{})
+	path := filepath.Join(dir, "broker.json")
+	b.SetHeartbeatFile(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	var h *Heartbeat
+	waitFor(t, "heartbeat", func() bool {
+		h, _ = ReadHeartbeat(path)
+		return h != nil
+	})
+	if h.PID != os.Getpid() || !h.Alive(time.Now()) {
+		t.Errorf("expected a live heartbeat from this process, got %+v", h)
+	}
+	if h.Alive(h.UpdatedAt.Add(3 * HeartbeatInterval)) {
+		t.Error("expected a heartbeat three intervals old to be dead")
+	}
+
+	cancel()
+	<-done
+	if _, err := ReadHeartbeat(path); !os.IsNotExist(err) {
+		t.Errorf("expected heartbeat removed on shutdown, got %v", err)
+	}
+}
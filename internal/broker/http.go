@@ -0,0 +1,178 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// DefaultMaxTokens caps each reply when HTTPExecutor.MaxTokens is unset
+const DefaultMaxTokens = 4096
+
+// anthropicVersion is the Messages API version sent with every request
+const anthropicVersion = "2023-06-01"
+
+// HTTPExecutor talks to an Anthropic Messages API compatible endpoint. The
+// API keeps no conversation state, so the executor stores each session's
+// transcript through the session manager and replays it every turn.
+// The API reports token usage but no price, so results carry no Cost and
+// budgets do not limit these agents.
+type HTTPExecutor struct {
+	BaseURL   string // e.g. http://localhost:8080; /v1/messages is appended
+	APIKey    string
+	Model     string
+	System    string
+	MaxTokens int
+	Sessions  *session.Manager
+	Client    *http.Client // Defaults to http.DefaultClient
+
+	// ForkSession copies the transcript to a new session ID before a resumed
+	// turn, leaving the original conversation untouched
+	ForkSession bool
+}
+
+// Forking returns a copy of the executor that branches resumed sessions
+func (e *HTTPExecutor) Forking() Executor {
+	fork := *e
+	fork.ForkSession = true
+	return &fork
+}
+
+type messagesRequest struct {
+	Model     string                      `json:"model"`
+	MaxTokens int                         `json:"max_tokens"`
+	System    string                      `json:"system,omitempty"`
+	Messages  []session.TranscriptMessage `json:"messages"`
+}
+
+type messagesResponse struct {
+	ID      string `json:"id"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      Usage  `json:"usage"`
+}
+
+type apiError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Execute sends the session's transcript plus message and records the
+// exchange once the endpoint answers. New and forked sessions get an ID of
+// the form http-<uuid>.
+func (e *HTTPExecutor) Execute(ctx context.Context, sessionID, message string, isNew bool) (*ExecuteResult, error) {
+	var history, copied []session.TranscriptMessage
+	if isNew {
+		sessionID = "http-" + uuid.New().String()
+	} else {
+		var err error
+		if history, err = e.Sessions.Transcript(sessionID); err != nil {
+			return nil, err
+		}
+		if e.ForkSession {
+			copied = slices.Clone(history)
+			sessionID = "http-" + uuid.New().String()
+		}
+	}
+
+	user := session.TranscriptMessage{Role: "user", Content: message}
+	maxTokens := e.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxTokens
+	}
+	body, err := json.Marshal(messagesRequest{
+		Model:     e.Model,
+		MaxTokens: maxTokens,
+		System:    e.System,
+		Messages:  append(history, user),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := e.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	assistant := session.TranscriptMessage{Role: "assistant", Content: text.String()}
+	if err := e.Sessions.AppendTranscript(sessionID, append(copied, user, assistant)...); err != nil {
+		return nil, err
+	}
+
+	return &ExecuteResult{
+		SessionID:   sessionID,
+		Response:    text.String(),
+		Usage:       resp.Usage,
+		Duration:    duration,
+		APIDuration: duration,
+		NumTurns:    1,
+		Subtype:     "success",
+	}, nil
+}
+
+func (e *HTTPExecutor) post(ctx context.Context, body []byte) (*messagesResponse, error) {
+	url := strings.TrimRight(e.BaseURL, "/") + "/v1/messages"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if e.APIKey != "" {
+		req.Header.Set("x-api-key", e.APIKey)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("endpoint returned %s: %s: %s", httpResp.Status, apiErr.Error.Type, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("endpoint returned %s: %s", httpResp.Status, data)
+	}
+
+	var resp messagesResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &resp, nil
+}
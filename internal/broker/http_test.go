@@ -0,0 +1,184 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// fakeMessagesServer answers Messages API requests by reporting how many
+// messages it was sent and echoing the last one
+type fakeMessagesServer struct {
+	mu       sync.Mutex
+	requests []messagesRequest
+	headers  []http.Header
+}
+
+func (f *fakeMessagesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/messages" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req messagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"type":"error","error":{"type":"invalid_request_error","message":%q}}`, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.headers = append(f.headers, r.Header.Clone())
+	f.mu.Unlock()
+
+	last := req.Messages[len(req.Messages)-1].Content
+	if last == "overloaded" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"%d messages, last: %s"}],`+
+		`"stop_reason":"end_turn","usage":{"input_tokens":%d,"output_tokens":7}}`,
+		len(req.Messages), last, 10*len(req.Messages))
+}
+
+func newHTTPExecutor(t *testing.T) (*HTTPExecutor, *fakeMessagesServer, *session.Manager) {
+	t.Helper()
+	fake := &fakeMessagesServer{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	sMgr, _ := session.NewManager(t.TempDir())
+	exec := &HTTPExecutor{
+		BaseURL:  server.URL,
+		APIKey:   "test-key",
+		Model:    "test-model",
+		System:   "Be brief.",
+		Sessions: sMgr,
+	}
+	return exec, fake, sMgr
+}
+
+func TestHTTPExecutorImplementsInterface(t *testing.T) {
+	var _ Executor = (*HTTPExecutor)(nil)
+}
+
+func TestHTTPExecutor_Conversation(t *testing.T) {
+	exec, fake, sMgr := newHTTPExecutor(t)
+
+	first, err := exec.Execute(context.Background(), "", "hello", true)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !strings.HasPrefix(first.SessionID, "http-") {
+		t.Errorf("expected synthetic session ID, got %q", first.SessionID)
+	}
+	if first.Response != "1 messages, last: hello" {
+		t.Errorf("unexpected response: %q", first.Response)
+	}
+	if first.Usage.InputTokens != 10 || first.Usage.OutputTokens != 7 || first.NumTurns != 1 {
+		t.Errorf("unexpected usage: %+v", first)
+	}
+
+	// The next turn replays the transcript
+	second, err := exec.Execute(context.Background(), first.SessionID, "again", false)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if second.SessionID != first.SessionID || second.Response != "3 messages, last: again" {
+		t.Errorf("unexpected second turn: %+v", second)
+	}
+
+	req := fake.requests[1]
+	if req.Model != "test-model" || req.System != "Be brief." || req.MaxTokens != DefaultMaxTokens {
+		t.Errorf("unexpected request settings: %+v", req)
+	}
+	if req.Messages[1].Role != "assistant" || req.Messages[1].Content != first.Response {
+		t.Errorf("expected the earlier reply replayed, got %+v", req.Messages)
+	}
+	h := fake.headers[0]
+	if h.Get("x-api-key") != "test-key" || h.Get("anthropic-version") == "" {
+		t.Errorf("missing API headers: %v", h)
+	}
+
+	transcript, _ := sMgr.Transcript(first.SessionID)
+	if len(transcript) != 4 {
+		t.Errorf("expected 4 transcript messages, got %d", len(transcript))
+	}
+}
+
+func TestHTTPExecutor_ErrorResponse(t *testing.T) {
+	exec, _, sMgr := newHTTPExecutor(t)
+
+	first, _ := exec.Execute(context.Background(), "", "hello", true)
+	_, err := exec.Execute(context.Background(), first.SessionID, "overloaded", false)
+	if err == nil || !strings.Contains(err.Error(), "Overloaded") {
+		t.Fatalf("expected the API error message, got %v", err)
+	}
+
+	// A failed turn is not recorded, so a retry does not repeat the message
+	transcript, _ := sMgr.Transcript(first.SessionID)
+	if len(transcript) != 2 {
+		t.Errorf("expected failed turn left out of transcript, got %d messages", len(transcript))
+	}
+}
+
+func TestHTTPExecutor_BrokerEndToEnd(t *testing.T) {
+	exec, fake, sMgr := newHTTPExecutor(t)
+	qMgr, _ := queue.NewManager(t.TempDir())
+
+	b, _ := NewBroker(qMgr, sMgr, exec)
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+	b.SetRouting(true, 3)
+
+	var mu sync.Mutex
+	var replies []*schema.Message
+	b.SetResponseHandler(func(msg *schema.Message) {
+		mu.Lock()
+		replies = append(replies, msg)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// agent-a's reply is routed to agent-b and so on until the hop limit
+	b.SendMessage(schema.NewMessage(schema.AgentB, schema.AgentA, schema.TypeMessage, "ping"))
+	waitFor(t, "three routed replies", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(replies) >= 3
+	})
+	cancel()
+	<-done
+
+	sessA, _ := sMgr.GetSession(schema.AgentA)
+	sessB, _ := sMgr.GetSession(schema.AgentB)
+	if !strings.HasPrefix(sessA.SessionID, "http-") || sessA.SessionID == sessB.SessionID {
+		t.Errorf("expected separate synthetic sessions, got %q and %q", sessA.SessionID, sessB.SessionID)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	last := fake.requests[len(fake.requests)-1]
+	if len(last.Messages) != 3 {
+		t.Errorf("expected agent-a's second turn to carry its transcript, got %d messages", len(last.Messages))
+	}
+}
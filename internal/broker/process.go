@@ -0,0 +1,70 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// DefaultKillGrace is how long a cancelled process group has to exit after
+// SIGTERM before it is sent SIGKILL
+const DefaultKillGrace = 5 * time.Second
+
+// TimeoutError reports a turn that ran past its deadline. Stdout and Stderr
+// hold whatever the process wrote before it was stopped.
+type TimeoutError struct {
+	Timeout time.Duration // Zero if not known
+	Stdout  string
+	Stderr  string
+}
+
+func (e *TimeoutError) Error() string {
+	msg := "turn timed out"
+	if e.Timeout > 0 {
+		msg += " after " + e.Timeout.String()
+	}
+	if e.Stderr != "" {
+		msg += ", stderr: " + e.Stderr
+	}
+	return msg
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// runCommand runs name in its own process group, so cancelling ctx also
+// stops the subprocesses it started: the group is sent SIGTERM, then SIGKILL
+// once grace has passed. stdout is returned even if the command fails, and a
+// ctx deadline is reported as a *TimeoutError carrying the captured output.
+func runCommand(ctx context.Context, name string, args []string, dir string, stdin io.Reader, grace time.Duration) ([]byte, error) {
+	if grace <= 0 {
+		grace = DefaultKillGrace
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	group := newProcessGroup(cmd, grace)
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		group.stop()
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return stdout.Bytes(), &TimeoutError{Stdout: stdout.String(), Stderr: stderr.String()}
+	}
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("%s command failed: %w, stderr: %s", name, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
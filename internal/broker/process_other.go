@@ -0,0 +1,19 @@
+//go:build !unix
+
+package broker
+
+import (
+	"os/exec"
+	"time"
+)
+
+// Without process groups only the direct child is stopped, by the default
+// context cancellation
+type processGroup struct{}
+
+func newProcessGroup(cmd *exec.Cmd, grace time.Duration) *processGroup {
+	cmd.WaitDelay = grace
+	return &processGroup{}
+}
+
+func (g *processGroup) stop() {}
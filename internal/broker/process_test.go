@@ -0,0 +1,86 @@
+//go:build unix
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// alive reports whether pid is a running process; zombies count as gone
+func alive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat))
+	return len(fields) > 2 && fields[2] != "Z"
+}
+
+func TestRunCommand_TimeoutKeepsOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	output, err := runCommand(ctx, "sh", []string{"-c", "echo started; echo warming up >&2; sleep 10"}, "", nil, 300*time.Millisecond)
+
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected TimeoutError to match context.DeadlineExceeded")
+	}
+	if te.Stdout != "started\n" || te.Stderr != "warming up\n" || string(output) != "started\n" {
+		t.Errorf("expected partial output kept, got stdout %q, stderr %q", te.Stdout, te.Stderr)
+	}
+}
+
+func TestRunCommand_KillsProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// The grandchild stands in for a tool claude started
+	output, err := runCommand(ctx, "sh", []string{"-c", "sleep 30 & echo $!; wait"}, "", nil, 300*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected timeout")
+	}
+
+	pid, perr := strconv.Atoi(strings.TrimSpace(string(output)))
+	if perr != nil {
+		t.Fatalf("expected grandchild PID in output, got %q", output)
+	}
+	if alive(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("expected grandchild to be killed with its process group")
+	}
+}
+
+func TestRunCommand_KillsAfterGrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := runCommand(ctx, "sh", []string{"-c", `trap "" TERM; echo ready; sleep 30`}, "", nil, 200*time.Millisecond)
+	elapsed := time.Since(start)
+
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("expected SIGKILL after the grace period, took %v", elapsed)
+	}
+	if te.Stdout != "ready\n" {
+		t.Errorf("expected partial output kept, got %q", te.Stdout)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package broker
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// processGroup puts a command in a process group of its own so it can be
+// signalled together with its descendants
+type processGroup struct {
+	cmd   *exec.Cmd
+	grace time.Duration
+
+	mu       sync.Mutex
+	signaled time.Time
+}
+
+func newProcessGroup(cmd *exec.Cmd, grace time.Duration) *processGroup {
+	g := &processGroup{cmd: cmd, grace: grace}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = g.terminate
+	// Stops waiting on output pipes held open by descendants that ignore
+	// SIGTERM; stop kills them afterwards
+	cmd.WaitDelay = grace
+	return g
+}
+
+func (g *processGroup) terminate() error {
+	g.mu.Lock()
+	g.signaled = time.Now()
+	g.mu.Unlock()
+	return syscall.Kill(-g.cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// stop gives the rest of the group what remains of the grace period to
+// exit, then kills whatever is left
+func (g *processGroup) stop() {
+	if g.cmd.Process == nil {
+		return
+	}
+	pgid := -g.cmd.Process.Pid
+
+	g.mu.Lock()
+	deadline := g.signaled.Add(g.grace)
+	g.mu.Unlock()
+
+	for time.Now().Before(deadline) && syscall.Kill(pgid, 0) == nil {
+		time.Sleep(20 * time.Millisecond)
+	}
+	syscall.Kill(pgid, syscall.SIGKILL)
+}
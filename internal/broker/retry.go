@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+)
+
+// RetryPolicy controls how failed turns are retried before a message is
+// moved to its agent's dead-letter queue
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy tries each message three times, backing off from 5s
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+}
+
+// Backoff returns the delay before the retry that follows the given attempt.
+// The delay doubles with each attempt, capped at MaxBackoff.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
+
+// SetRetryPolicy sets how failed turns are retried. The zero policy moves a
+// message to the dead-letter queue after its first failure.
+func (b *Broker) SetRetryPolicy(p RetryPolicy) {
+	b.retry = p
+}
+
+// fail records a failed attempt at a leased message. The message is nacked
+// back to its queue after a backoff delay, or moved to the dead-letter queue
+// once its attempts are exhausted. The returned error wraps cause.
+func (b *Broker) fail(q *queue.Queue, agentID string, lease *queue.Lease, cause error) error {
+	msg := lease.Message
+	msg.Attempts++
+	if msg.MaxAttempts == 0 {
+		msg.MaxAttempts = max(b.retry.MaxAttempts, 1)
+	}
+	msg.WithMetadata("error", cause.Error())
+
+	if msg.Attempts < msg.MaxAttempts {
+		due := time.Now().UTC().Add(b.retry.Backoff(msg.Attempts))
+		msg.NotBefore = &due
+
+		if err := q.Nack(lease); err != nil {
+			return fmt.Errorf("failed to requeue message: %w (after: %w)", err, cause)
+		}
+		b.record(agentID, history.EventFailed, msg)
+		return fmt.Errorf("attempt %d of %d failed, retrying at %s: %w",
+			msg.Attempts, msg.MaxAttempts, due.Format(time.RFC3339), cause)
+	}
+
+	msg.NotBefore = nil
+	var timeout *TimeoutError
+	if errors.As(cause, &timeout) && timeout.Stdout != "" {
+		msg.WithMetadata("partial_stdout", timeout.Stdout)
+	}
+
+	dlq, err := b.queueMgr.GetDeadLetterQueue(agentID)
+	if err != nil {
+		return fmt.Errorf("failed to get dead-letter queue: %w (after: %w)", err, cause)
+	}
+	if err := dlq.Enqueue(msg); err != nil {
+		return fmt.Errorf("failed to dead-letter message: %w (after: %w)", err, cause)
+	}
+	if err := q.Ack(lease); err != nil {
+		return fmt.Errorf("failed to ack dead-lettered message: %w (after: %w)", err, cause)
+	}
+	b.record(agentID, history.EventDeadLettered, msg)
+	if msg.CorrelationID != "" {
+		b.collect(msg.CorrelationID, agentID, "", cause)
+	}
+
+	return fmt.Errorf("attempt %d of %d failed, moved to dead-letter queue: %w",
+		msg.Attempts, msg.MaxAttempts, cause)
+}
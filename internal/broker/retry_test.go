@@ -0,0 +1,310 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/history"
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+// FailingExecutor fails a fixed number of times before succeeding
+type FailingExecutor struct {
+	failures int
+	calls    int
+}
+
+func (f *FailingExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("claude exploded")
+	}
+	return &ExecuteResult{SessionID: "session-1", Response: "recovered"}, nil
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, w, got)
+		}
+	}
+}
+
+func TestRetry_RequeuesWithBackoff(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &FailingExecutor{failures: 1})
+	b.InitializeAgent(schema.AgentA)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "try me"))
+
+	if _, err := b.ProcessNext(context.Background(), schema.AgentA); err == nil {
+		t.Fatal("expected error from failed attempt")
+	}
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := q.List()
+	if len(msgs) != 1 {
+		t.Fatalf("expected message to be requeued, got %d", len(msgs))
+	}
+	if msgs[0].Attempts != 1 || msgs[0].MaxAttempts != 3 {
+		t.Errorf("expected attempts 1/3, got %d/%d", msgs[0].Attempts, msgs[0].MaxAttempts)
+	}
+	if msgs[0].NotBefore == nil || time.Until(*msgs[0].NotBefore) < 59*time.Minute {
+		t.Errorf("expected retry to be scheduled an hour out, got %v", msgs[0].NotBefore)
+	}
+
+	// Not due yet, so nothing is processed
+	resp, err := b.ProcessNext(context.Background(), schema.AgentA)
+	if resp != nil || err != nil {
+		t.Errorf("expected nothing to process before backoff, got %v, %v", resp, err)
+	}
+}
+
+func TestRetry_SucceedsOnLaterAttempt(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &FailingExecutor{failures: 2})
+	b.InitializeAgent(schema.AgentA)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "eventually"))
+
+	var resp *schema.Message
+	for i := 0; i < 3; i++ {
+		resp, _ = b.ProcessNext(context.Background(), schema.AgentA)
+	}
+	if resp == nil || resp.Payload.Text != "recovered" {
+		t.Fatalf("expected recovery on third attempt, got %v", resp)
+	}
+}
+
+func TestRetry_DeadLettersAfterMaxAttempts(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+	hist, _ := history.NewStore(dir + "/history")
+
+	b, _ := NewBroker(qMgr, sMgr, &FailingExecutor{failures: 10})
+	b.InitializeAgent(schema.AgentA)
+	b.SetHistory(hist)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+
+	msg := schema.NewUserMessage(schema.AgentA, "doomed")
+	b.SendMessage(msg)
+	b.ProcessNext(context.Background(), schema.AgentA)
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	n, _ := q.Len()
+	if n != 0 {
+		t.Errorf("expected agent queue to be empty, got %d", n)
+	}
+
+	dlq, _ := qMgr.GetDeadLetterQueue(schema.AgentA)
+	dead, err := dlq.Get(msg.ID)
+	if err != nil {
+		t.Fatalf("expected message in dead-letter queue: %v", err)
+	}
+	if dead.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", dead.Attempts)
+	}
+	if dead.Payload.Metadata["error"] == "" {
+		t.Error("expected error recorded on dead letter")
+	}
+
+	records, _ := hist.Query(history.Filter{})
+	var events []string
+	for _, r := range records {
+		events = append(events, r.Event)
+	}
+	want := []string{history.EventEnqueued, history.EventStarted, history.EventFailed, history.EventStarted, history.EventDeadLettered}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("expected events %v, got %v", want, events)
+	}
+	if records[2].Message.Payload.Metadata["error"] == "" {
+		t.Error("expected the retried failure's error recorded")
+	}
+}
+
+func TestRetry_ZeroPolicyDeadLettersImmediately(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &FailingExecutor{failures: 1})
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "once"))
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	dlq, _ := qMgr.GetDeadLetterQueue(schema.AgentA)
+	n, _ := dlq.Len()
+	if n != 1 {
+		t.Errorf("expected 1 dead letter, got %d", n)
+	}
+}
+
+func TestRetry_InterruptedTurnKeepsAttempts(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, &FailingExecutor{failures: 1})
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "shutdown"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.ProcessNext(ctx, schema.AgentA)
+
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := q.List()
+	if len(msgs) != 1 {
+		t.Fatalf("expected interrupted message back in queue, got %d", len(msgs))
+	}
+	if msgs[0].Attempts != 0 {
+		t.Errorf("expected interrupted turn not to use an attempt, got %d", msgs[0].Attempts)
+	}
+}
+
+// ErrorResultExecutor completes every turn with an error result
+type ErrorResultExecutor struct{}
+
+func (ErrorResultExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	return &ExecuteResult{SessionID: "session-1", IsError: true, Subtype: "error_max_turns", NumTurns: 1}, nil
+}
+
+func TestRetry_ErrorResultIsFailure(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, ErrorResultExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	msg := schema.NewUserMessage(schema.AgentA, "too much work")
+	b.SendMessage(msg)
+
+	response, err := b.ProcessNext(context.Background(), schema.AgentA)
+	var resultErr *ResultError
+	if !errors.As(err, &resultErr) || resultErr.Subtype != "error_max_turns" {
+		t.Fatalf("expected ResultError, got %v", err)
+	}
+	if response != nil {
+		t.Errorf("expected no response for an error result, got %+v", response)
+	}
+
+	dlq, _ := qMgr.GetDeadLetterQueue(schema.AgentA)
+	if _, err := dlq.Get(msg.ID); err != nil {
+		t.Errorf("expected message dead-lettered: %v", err)
+	}
+
+	// The conversation claude started is kept for the next message
+	sess, _ := sMgr.GetSession(schema.AgentA)
+	if sess.SessionID != "session-1" {
+		t.Errorf("expected session ID kept, got %q", sess.SessionID)
+	}
+	if sess.TurnNumber != 0 {
+		t.Errorf("expected turn not counted, got %d", sess.TurnNumber)
+	}
+}
+
+// HangingExecutor blocks until its context is done
+type HangingExecutor struct{}
+
+func (HangingExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRetry_TurnTimeout(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, HangingExecutor{})
+	b.InitializeAgent(schema.AgentA)
+	b.InitializeAgent(schema.AgentB)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+	b.SetTurnTimeout(time.Hour)
+	b.SetAgentTurnTimeout(schema.AgentA, 50*time.Millisecond)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "hang"))
+	_, err := b.ProcessNext(context.Background(), schema.AgentA)
+
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected TimeoutError, got %v", err)
+	}
+	if te.Timeout != 50*time.Millisecond {
+		t.Errorf("expected the agent's timeout reported, got %v", te.Timeout)
+	}
+
+	// A timed-out turn uses an attempt, unlike a shutdown
+	q, _ := qMgr.GetQueue(schema.AgentA)
+	msgs, _ := q.List()
+	if len(msgs) != 1 || msgs[0].Attempts != 1 {
+		t.Fatalf("expected message requeued after 1 attempt, got %+v", msgs)
+	}
+
+	// Other agents keep the broker's default
+	b.SetTurnTimeout(30 * time.Millisecond)
+	b.SendMessage(schema.NewUserMessage(schema.AgentB, "hang"))
+	if _, err := b.ProcessNext(context.Background(), schema.AgentB); !errors.As(err, &te) || te.Timeout != 30*time.Millisecond {
+		t.Errorf("expected default timeout, got %v", err)
+	}
+}
+
+func TestRetry_TimeoutKeepsPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	b, _ := NewBroker(qMgr, sMgr, ErrorFuncExecutor(func(ctx context.Context) error {
+		<-ctx.Done()
+		return &TimeoutError{Stdout: "half a thought", Stderr: "still working"}
+	}))
+	b.InitializeAgent(schema.AgentA)
+	b.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	b.SetTurnTimeout(20 * time.Millisecond)
+
+	msg := schema.NewUserMessage(schema.AgentA, "think hard")
+	b.SendMessage(msg)
+	b.ProcessNext(context.Background(), schema.AgentA)
+
+	dlq, _ := qMgr.GetDeadLetterQueue(schema.AgentA)
+	dead, err := dlq.Get(msg.ID)
+	if err != nil {
+		t.Fatalf("expected message dead-lettered: %v", err)
+	}
+	if dead.Payload.Metadata["partial_stdout"] != "half a thought" {
+		t.Errorf("expected partial stdout kept, got %v", dead.Payload.Metadata)
+	}
+	if e := dead.Payload.Metadata["error"]; !strings.Contains(e, "timed out after 20ms") || !strings.Contains(e, "still working") {
+		t.Errorf("expected timeout and stderr in error, got %q", e)
+	}
+}
+
+// ErrorFuncExecutor fails every turn with the error f returns
+type ErrorFuncExecutor func(ctx context.Context) error
+
+func (f ErrorFuncExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	return nil, f(ctx)
+}
@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Event types parsed from claude's stream-json output
+const (
+	EventSystem     = "system"      // Session metadata; Subtype "init" comes first
+	EventText       = "text"        // Assistant text
+	EventToolUse    = "tool_use"    // Assistant tool call
+	EventToolResult = "tool_result" // Output of a tool call
+)
+
+// Event is one step of a streamed turn. Raw holds the stream line the event
+// came from, so fields not surfaced here remain available.
+type Event struct {
+	Type      string          `json:"type"`
+	Subtype   string          `json:"subtype,omitempty"`
+	Text      string          `json:"text,omitempty"` // Assistant text or tool result content
+	ToolName  string          `json:"tool_name,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Raw       json.RawMessage `json:"raw,omitempty"`
+}
+
+// ToolsUsed returns the distinct tools called during the turn, in the order
+// they were first used
+func (r *ExecuteResult) ToolsUsed() []string {
+	var tools []string
+	seen := make(map[string]bool)
+	for _, e := range r.Events {
+		if e.Type == EventToolUse && !seen[e.ToolName] {
+			seen[e.ToolName] = true
+			tools = append(tools, e.ToolName)
+		}
+	}
+	return tools
+}
+
+// streamLine is the envelope of every stream-json line. The result fields
+// are only set on the final line.
+type streamLine struct {
+	claudeOutput
+	Message *streamMessage `json:"message"`
+}
+
+type streamMessage struct {
+	Content []contentBlock `json:"content"`
+}
+
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	IsError   bool            `json:"is_error"`
+}
+
+// ParseStream parses claude's stream-json output into a result carrying
+// every event of the turn. The final result line supplies the session ID,
+// response text, cost and usage.
+func ParseStream(output []byte) (*ExecuteResult, error) {
+	var result *ExecuteResult
+	var events []Event
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var sl streamLine
+		if err := json.Unmarshal(line, &sl); err != nil {
+			return nil, fmt.Errorf("failed to parse stream line: %w", err)
+		}
+		raw := json.RawMessage(bytes.Clone(line))
+
+		switch sl.Type {
+		case "system":
+			events = append(events, Event{Type: EventSystem, Subtype: sl.Subtype, Raw: raw})
+		case "assistant", "user":
+			if sl.Message == nil {
+				continue
+			}
+			for _, block := range sl.Message.Content {
+				if e, ok := blockEvent(block); ok {
+					e.Raw = raw
+					events = append(events, e)
+				}
+			}
+		case "result":
+			result = sl.toResult()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("failed to parse claude output: stream ended without a result")
+	}
+	result.Events = events
+	return result, nil
+}
+
+// blockEvent converts a message content block to an event. Block types
+// without an event, such as thinking, are skipped.
+func blockEvent(block contentBlock) (Event, bool) {
+	switch block.Type {
+	case "text":
+		return Event{Type: EventText, Text: block.Text}, true
+	case "tool_use":
+		return Event{
+			Type:      EventToolUse,
+			ToolName:  block.Name,
+			ToolUseID: block.ID,
+			ToolInput: block.Input,
+		}, true
+	case "tool_result":
+		return Event{
+			Type:      EventToolResult,
+			ToolUseID: block.ToolUseID,
+			Text:      toolResultText(block.Content),
+			IsError:   block.IsError,
+		}, true
+	}
+	return Event{}, false
+}
+
+// toolResultText flattens tool result content, which is either a string or
+// a list of content blocks
+func toolResultText(content json.RawMessage) string {
+	if len(content) == 0 {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(content, &s); err == nil {
+		return s
+	}
+
+	var blocks []contentBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return string(content)
+	}
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
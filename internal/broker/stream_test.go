@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/queue"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+	"github.com/binaryphile/cc-bridge/internal/session"
+)
+
+const streamFixture = `{"type":"system","subtype":"init","session_id":"abc-123","tools":["Bash","Read"],"model":"claude"}
+{"type":"assistant","message":{"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"ls"}}]},"session_id":"abc-123"}
+{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"go.mod\nREADME.md"}]},"session_id":"abc-123"}
+{"type":"assistant","message":{"content":[{"type":"tool_use","id":"toolu_2","name":"Read","input":{"file_path":"go.mod"}}]},"session_id":"abc-123"}
+{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_2","content":[{"type":"text","text":"module x"}],"is_error":true}]},"session_id":"abc-123"}
+{"type":"assistant","message":{"content":[{"type":"tool_use","id":"toolu_3","name":"Bash","input":{"command":"pwd"}}]},"session_id":"abc-123"}
+{"type":"result","subtype":"success","is_error":false,"duration_ms":5120,"duration_api_ms":4300,"num_turns":4,"result":"Two files.","session_id":"abc-123","total_cost_usd":0.0042,"usage":{"input_tokens":12,"output_tokens":80,"cache_read_input_tokens":9000}}
+`
+
+func TestParseStream(t *testing.T) {
+	result, err := ParseStream([]byte(streamFixture))
+	if err != nil {
+		t.Fatalf("ParseStream failed: %v", err)
+	}
+
+	if result.SessionID != "abc-123" || result.Response != "Two files." || result.Cost != 0.0042 {
+		t.Errorf("unexpected result: %q %q %f", result.SessionID, result.Response, result.Cost)
+	}
+	if result.NumTurns != 4 || result.Duration != 5120*time.Millisecond || result.Usage.CacheReadInputTokens != 9000 {
+		t.Errorf("expected result line details, got %+v", result)
+	}
+
+	want := []string{EventSystem, EventText, EventToolUse, EventToolResult, EventToolUse, EventToolResult, EventToolUse}
+	if len(result.Events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(result.Events), result.Events)
+	}
+	for i, typ := range want {
+		if result.Events[i].Type != typ {
+			t.Errorf("event %d: expected %s, got %s", i, typ, result.Events[i].Type)
+		}
+	}
+
+	if e := result.Events[0]; e.Subtype != "init" {
+		t.Errorf("expected system init, got subtype %q", e.Subtype)
+	}
+	if e := result.Events[2]; e.ToolName != "Bash" || e.ToolUseID != "toolu_1" || !strings.Contains(string(e.ToolInput), "ls") {
+		t.Errorf("unexpected tool_use event: %+v", e)
+	}
+	if e := result.Events[3]; e.ToolUseID != "toolu_1" || e.Text != "go.mod\nREADME.md" {
+		t.Errorf("unexpected tool_result event: %+v", e)
+	}
+	if e := result.Events[5]; e.Text != "module x" || !e.IsError {
+		t.Errorf("expected block content flattened and error flagged: %+v", e)
+	}
+}
+
+func TestToolsUsed(t *testing.T) {
+	result, _ := ParseStream([]byte(streamFixture))
+
+	tools := result.ToolsUsed()
+	if len(tools) != 2 || tools[0] != "Bash" || tools[1] != "Read" {
+		t.Errorf("expected [Bash Read], got %v", tools)
+	}
+
+	if tools := (&ExecuteResult{}).ToolsUsed(); len(tools) != 0 {
+		t.Errorf("expected no tools without events, got %v", tools)
+	}
+}
+
+func TestParseStream_NoResult(t *testing.T) {
+	_, err := ParseStream([]byte(`{"type":"system","subtype":"init"}` + "\n"))
+	if err == nil {
+		t.Error("expected error when the stream has no result line")
+	}
+}
+
+func TestParseStream_InvalidLine(t *testing.T) {
+	_, err := ParseStream([]byte("not json\n"))
+	if err == nil {
+		t.Error("expected error for invalid stream line")
+	}
+}
+
+// StreamingExecutor returns a canned streamed result
+type StreamingExecutor struct {
+	result *ExecuteResult
+}
+
+func (s *StreamingExecutor) Execute(ctx context.Context, sessionID string, message string, isNew bool) (*ExecuteResult, error) {
+	return s.result, nil
+}
+
+func TestProcessNext_RecordsResultDetails(t *testing.T) {
+	dir := t.TempDir()
+	qMgr, _ := queue.NewManager(dir + "/queues")
+	sMgr, _ := session.NewManager(dir + "/sessions")
+
+	result, _ := ParseStream([]byte(streamFixture))
+	b, _ := NewBroker(qMgr, sMgr, &StreamingExecutor{result: result})
+	b.InitializeAgent(schema.AgentA)
+
+	b.SendMessage(schema.NewUserMessage(schema.AgentA, "list files"))
+	resp, err := b.ProcessNext(context.Background(), schema.AgentA)
+	if err != nil {
+		t.Fatalf("ProcessNext failed: %v", err)
+	}
+	if got := resp.Payload.Metadata["tools"]; got != "Bash,Read" {
+		t.Errorf("expected tools metadata 'Bash,Read', got %q", got)
+	}
+
+	want := map[string]string{
+		"output_tokens":           "80",
+		"cache_read_input_tokens": "9000",
+		"duration_ms":             "5120",
+		"duration_api_ms":         "4300",
+		"num_turns":               "4",
+		"subtype":                 "success",
+	}
+	for key, value := range want {
+		if got := resp.Payload.Metadata[key]; got != value {
+			t.Errorf("expected %s=%s, got %q", key, value, got)
+		}
+	}
+}
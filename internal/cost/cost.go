@@ -0,0 +1,161 @@
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
+)
+
+// Spend is the money and turns used by one agent or run
+type Spend struct {
+	USD   float64 `json:"usd"`
+	Turns int     `json:"turns"`
+}
+
+// Run is the spend of one broker run, with the budgets it was started with.
+// Zero budgets mean no limit.
+type Run struct {
+	ID          string            `json:"id"`
+	StartedAt   time.Time         `json:"started_at"`
+	Budget      float64           `json:"budget,omitempty"`
+	AgentBudget float64           `json:"agent_budget,omitempty"` // Default for agents without their own
+	Spend       Spend             `json:"spend"`
+	Agents      map[string]*Spend `json:"agents"`
+}
+
+// Totals is the content of costs.json. Agent spend accumulates across runs;
+// Run only covers the latest broker run.
+type Totals struct {
+	Agents map[string]*Spend `json:"agents"`
+	Run    *Run              `json:"run,omitempty"`
+}
+
+// Agent returns an agent's spend, which is zero if it has none recorded
+func (t *Totals) Agent(id string) Spend {
+	if s, ok := t.Agents[id]; ok {
+		return *s
+	}
+	return Spend{}
+}
+
+// Ledger records spend in costs.json. Like the agent registry it holds no
+// state in memory, so the CLI sees what a running broker has spent.
+type Ledger struct {
+	dir string
+}
+
+// NewLedger opens the ledger kept in dir, creating the directory if needed
+func NewLedger(dir string) (*Ledger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cost directory: %w", err)
+	}
+	return &Ledger{dir: dir}, nil
+}
+
+// StartRun begins a new broker run, replacing the previous run's totals
+func (l *Ledger) StartRun(budget, agentBudget float64) (*Totals, error) {
+	return l.update(func(t *Totals) {
+		t.Run = &Run{
+			ID:          uuid.New().String(),
+			StartedAt:   time.Now().UTC(),
+			Budget:      budget,
+			AgentBudget: agentBudget,
+			Agents:      make(map[string]*Spend),
+		}
+	})
+}
+
+// Add records a turn of agent costing usd against the agent and the current
+// run, and returns the new totals
+func (l *Ledger) Add(agent string, usd float64) (*Totals, error) {
+	return l.update(func(t *Totals) {
+		add(t.Agents, agent, usd)
+		if t.Run != nil {
+			add(t.Run.Agents, agent, usd)
+			t.Run.Spend.USD += usd
+			t.Run.Spend.Turns++
+		}
+	})
+}
+
+func add(spends map[string]*Spend, agent string, usd float64) {
+	s, ok := spends[agent]
+	if !ok {
+		s = &Spend{}
+		spends[agent] = s
+	}
+	s.USD += usd
+	s.Turns++
+}
+
+// Read returns the recorded totals
+func (l *Ledger) Read() (*Totals, error) {
+	lock, err := fsutil.RLockFile(l.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock costs: %w", err)
+	}
+	defer lock.Unlock()
+
+	return l.readFile()
+}
+
+// Path returns the location of costs.json
+func (l *Ledger) Path() string {
+	return filepath.Join(l.dir, "costs.json")
+}
+
+func (l *Ledger) update(fn func(t *Totals)) (*Totals, error) {
+	lock, err := fsutil.LockFile(l.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock costs: %w", err)
+	}
+	defer lock.Unlock()
+
+	t, err := l.readFile()
+	if err != nil {
+		return nil, err
+	}
+	fn(t)
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal costs: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(l.Path(), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write costs: %w", err)
+	}
+	return t, nil
+}
+
+func (l *Ledger) readFile() (*Totals, error) {
+	t := &Totals{Agents: make(map[string]*Spend)}
+
+	data, err := os.ReadFile(l.Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to read costs: %w", err)
+	}
+
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal costs: %w", err)
+	}
+	if t.Agents == nil {
+		t.Agents = make(map[string]*Spend)
+	}
+	if t.Run != nil && t.Run.Agents == nil {
+		t.Run.Agents = make(map[string]*Spend)
+	}
+	return t, nil
+}
+
+func (l *Ledger) lockPath() string {
+	return filepath.Join(l.dir, ".costs.lock")
+}
@@ -0,0 +1,54 @@
+package cost
+
+import (
+	"testing"
+)
+
+func TestAddAccumulatesAcrossRuns(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := NewLedger(dir)
+
+	l.StartRun(5, 1)
+	l.Add("agent-a", 0.25)
+	totals, err := l.Add("agent-a", 0.5)
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if a := totals.Agent("agent-a"); a.USD != 0.75 || a.Turns != 2 {
+		t.Errorf("unexpected agent spend: %+v", a)
+	}
+	if totals.Run.Spend.USD != 0.75 || totals.Run.Budget != 5 || totals.Run.AgentBudget != 1 {
+		t.Errorf("unexpected run: %+v", totals.Run)
+	}
+
+	// A new run starts from zero while agent totals carry over
+	first := totals.Run.ID
+	l.StartRun(0, 0)
+	totals, _ = l.Add("agent-a", 1)
+	if totals.Run.ID == first || totals.Run.Spend.USD != 1 || totals.Run.Agents["agent-a"].Turns != 1 {
+		t.Errorf("expected a fresh run, got %+v", totals.Run)
+	}
+	if totals.Agent("agent-a").USD != 1.75 {
+		t.Errorf("expected agent spend to accumulate, got %+v", totals.Agent("agent-a"))
+	}
+}
+
+func TestReadSharedAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	broker, _ := NewLedger(dir)
+	cli, _ := NewLedger(dir)
+
+	totals, err := cli.Read()
+	if err != nil || totals.Run != nil || len(totals.Agents) != 0 {
+		t.Fatalf("expected empty totals, got %+v, %v", totals, err)
+	}
+
+	broker.Add("agent-b", 0.1)
+	totals, _ = cli.Read()
+	if totals.Agent("agent-b").USD != 0.1 {
+		t.Errorf("expected spend visible to another instance, got %+v", totals.Agents)
+	}
+	if totals.Agent("missing").USD != 0 {
+		t.Error("expected zero spend for unknown agent")
+	}
+}
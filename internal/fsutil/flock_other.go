@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fsutil
+
+import "os"
+
+// Without flock, only the in-process locking of callers applies
+func flock(f *os.File, exclusive bool) error { return nil }
+
+func funlock(f *os.File) error { return nil }
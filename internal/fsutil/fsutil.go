@@ -0,0 +1,95 @@
+// Package fsutil provides file operations that are safe when several
+// cc-bridge processes share a data directory.
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it into place, so readers never observe a partially written file.
+// The temporary name does not end in the target's extension, which keeps it
+// out of directory listings that filter by extension.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// Lock is an advisory lock on a file, held until Unlock
+type Lock struct {
+	file *os.File
+}
+
+// LockFile takes an exclusive advisory lock on path, creating it if needed.
+// It blocks until the lock is available.
+func LockFile(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return lock(f, path, true)
+}
+
+// RLockFile takes a shared advisory lock on path. Readers never create the
+// lock file: if it does not exist no writer has taken the lock yet, so there
+// is nothing to wait for and the returned lock holds nothing.
+func RLockFile(path string) (*Lock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Lock{}, nil
+		}
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return lock(f, path, false)
+}
+
+func lock(f *os.File, path string, exclusive bool) (*Lock, error) {
+	if err := flock(f, exclusive); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{file: f}, nil
+}
+
+// Unlock releases the lock
+func (l *Lock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := funlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
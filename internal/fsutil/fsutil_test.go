@@ -0,0 +1,130 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "msg.json")
+
+	if err := WriteFileAtomic(path, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Errorf("unexpected contents: %q", data)
+	}
+
+	// No temp files left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the target file, got %d entries", len(entries))
+	}
+}
+
+func TestWriteFileAtomic_Overwrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	WriteFileAtomic(path, []byte("old"), 0644)
+	WriteFileAtomic(path, []byte("new"), 0644)
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "new" {
+		t.Errorf("expected overwrite, got %q", data)
+	}
+}
+
+func TestLockFile_Exclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".lock")
+
+	first, err := LockFile(path)
+	if err != nil {
+		t.Fatalf("LockFile failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	acquired := false
+	done := make(chan struct{})
+	go func() {
+		second, err := LockFile(path)
+		if err != nil {
+			t.Errorf("second LockFile failed: %v", err)
+			close(done)
+			return
+		}
+		mu.Lock()
+		acquired = true
+		mu.Unlock()
+		second.Unlock()
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if acquired {
+		t.Error("second lock acquired while first was held")
+	}
+	mu.Unlock()
+
+	first.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second lock not acquired after unlock")
+	}
+}
+
+func TestRLockFile_Shared(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".lock")
+	if l, err := LockFile(path); err == nil {
+		l.Unlock()
+	}
+
+	first, err := RLockFile(path)
+	if err != nil {
+		t.Fatalf("RLockFile failed: %v", err)
+	}
+	defer first.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		second, err := RLockFile(path)
+		if err == nil {
+			second.Unlock()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shared locks should not block each other")
+	}
+}
+
+func TestRLockFile_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+
+	l, err := RLockFile(path)
+	if err != nil {
+		t.Fatalf("RLockFile failed: %v", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected a shared lock not to create %s, got %v", path, err)
+	}
+}
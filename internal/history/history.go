@@ -0,0 +1,272 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+const (
+	EventEnqueued  = "enqueued"
+	EventStarted   = "started" // A turn for the message began
+	EventProcessed = "processed"
+	EventResponse  = "response"
+
+	// EventFailed is a failed attempt that will be retried; the message's
+	// error metadata says why
+	EventFailed       = "failed"
+	EventDeadLettered = "dead_lettered"
+)
+
+type Record struct {
+	Time    time.Time       `json:"time"`
+	Event   string          `json:"event"`
+	Message *schema.Message `json:"message"`
+}
+
+// MalformedError reports history lines that could not be parsed, such as a
+// line cut short by a crash. A read that returns it still returns every
+// record it could parse.
+type MalformedError struct {
+	Skipped int
+	Err     error // Why the first skipped line failed to parse
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("skipped %d malformed history record(s): %v", e.Skipped, e.Err)
+}
+
+func (e *MalformedError) Unwrap() error {
+	return e.Err
+}
+
+// skip notes a line that failed to parse, creating the error on the first
+func skip(bad **MalformedError, err error) {
+	if *bad == nil {
+		*bad = &MalformedError{Err: err}
+	}
+	(*bad).Skipped++
+}
+
+type Filter struct {
+	Agent string
+	Peer  string
+	From  string // Sender
+	Type  string
+	Since time.Time
+	Until time.Time
+}
+
+// Store is an append-only log of message events, one JSON record per line
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &Store{path: filepath.Join(dir, "history.jsonl")}, nil
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+func (s *Store) Append(event string, msg *schema.Message) error {
+	data, err := json.Marshal(&Record{
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Message: msg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal history record: %w", err)
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A single O_APPEND write keeps lines from interleaving across processes
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	return nil
+}
+
+// Query returns the records matching f. Malformed lines are skipped and
+// reported with a *MalformedError alongside the records.
+func (s *Store) Query(f Filter) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	var records []*Record
+	var bad *MalformedError
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r, err := parseRecord(scanner.Bytes())
+		if err != nil {
+			skip(&bad, err)
+			continue
+		}
+		if f.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	if bad != nil {
+		return records, bad
+	}
+	return records, nil
+}
+
+// Cursor reads the records appended to a history after some point, so a
+// reader can follow the log without rereading it
+type Cursor struct {
+	path    string
+	offset  int64
+	partial []byte
+}
+
+// Cursor returns a cursor at the end of the history. Only records appended
+// after this call are returned by Next.
+func (s *Store) Cursor() (*Cursor, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Cursor{path: s.path}, nil
+		}
+		return nil, fmt.Errorf("failed to stat history: %w", err)
+	}
+	return &Cursor{path: s.path, offset: info.Size()}, nil
+}
+
+// CursorAtStart returns a cursor whose first Next reads the whole history
+func (s *Store) CursorAtStart() *Cursor {
+	return &Cursor{path: s.path}
+}
+
+// Next returns the records appended since the previous call. A line still
+// being written is held back until it is complete. Malformed lines are
+// skipped and reported with a *MalformedError alongside the records.
+func (c *Cursor) Next() ([]*Record, error) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(c.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek history: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	c.offset += int64(len(data))
+	data = append(c.partial, data...)
+
+	var records []*Record
+	var bad *MalformedError
+	for {
+		line, rest, found := bytes.Cut(data, []byte{'\n'})
+		if !found {
+			break
+		}
+		data = rest
+		if len(line) == 0 {
+			continue
+		}
+		r, err := parseRecord(line)
+		if err != nil {
+			skip(&bad, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	c.partial = slices.Clone(data)
+	if bad != nil {
+		return records, bad
+	}
+	return records, nil
+}
+
+// parseRecord decodes one history line. Every record carries a message, so a
+// line without one is as malformed as one that fails to decode.
+func parseRecord(line []byte) (*Record, error) {
+	var r Record
+	if err := json.Unmarshal(line, &r); err != nil {
+		return nil, err
+	}
+	if r.Message == nil {
+		return nil, errors.New("record has no message")
+	}
+	return &r, nil
+}
+
+// Match reports whether a record passes the filter. Agent and Peer match
+// either end of a message; together they select one conversation.
+func (f Filter) Match(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+
+	msg := r.Message
+	if msg == nil {
+		return f.Agent == "" && f.Peer == "" && f.From == "" && f.Type == ""
+	}
+	if f.Type != "" && msg.Type != f.Type {
+		return false
+	}
+	if f.From != "" && msg.From != f.From {
+		return false
+	}
+
+	switch {
+	case f.Agent != "" && f.Peer != "":
+		return (msg.From == f.Agent && msg.To == f.Peer) || (msg.From == f.Peer && msg.To == f.Agent)
+	case f.Agent != "":
+		return msg.From == f.Agent || msg.To == f.Agent
+	case f.Peer != "":
+		return msg.From == f.Peer || msg.To == f.Peer
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestNewStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	if s == nil {
+		t.Fatal("expected non-nil Store")
+	}
+}
+
+func TestQueryEmpty(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	records, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query on empty store should not error: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records, got %d", len(records))
+	}
+}
+
+func TestAppendQuery(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	msg := schema.NewUserMessage(schema.AgentA, "hello")
+	if err := s.Append(EventEnqueued, msg); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	s.Append(EventProcessed, msg)
+
+	records, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Event != EventEnqueued || records[1].Event != EventProcessed {
+		t.Errorf("unexpected event order: %q, %q", records[0].Event, records[1].Event)
+	}
+	if records[0].Message.ID != msg.ID {
+		t.Errorf("expected message ID %q, got %q", msg.ID, records[0].Message.ID)
+	}
+}
+
+func TestQueryPersistsAcrossStores(t *testing.T) {
+	dir := t.TempDir()
+	s1, _ := NewStore(dir)
+	s1.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "hello"))
+
+	s2, _ := NewStore(dir)
+	records, _ := s2.Query(Filter{})
+	if len(records) != 1 {
+		t.Errorf("expected 1 record from second store, got %d", len(records))
+	}
+}
+
+func TestFilterAgentAndPeer(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "human to a"))
+	s.Append(EventEnqueued, schema.NewAgentMessage(schema.AgentA, schema.AgentB, "a to b"))
+	s.Append(EventEnqueued, schema.NewAgentMessage(schema.AgentB, schema.AgentA, "b to a"))
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentB, "human to b"))
+
+	records, _ := s.Query(Filter{Agent: schema.AgentA})
+	if len(records) != 3 {
+		t.Errorf("expected 3 records involving agent-a, got %d", len(records))
+	}
+
+	records, _ = s.Query(Filter{Agent: schema.AgentA, Peer: schema.AgentB})
+	if len(records) != 2 {
+		t.Errorf("expected 2 records between agent-a and agent-b, got %d", len(records))
+	}
+
+	records, _ = s.Query(Filter{Peer: schema.Human})
+	if len(records) != 2 {
+		t.Errorf("expected 2 records involving human, got %d", len(records))
+	}
+}
+
+func TestFilterType(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "normal"))
+	s.Append(EventEnqueued, schema.NewMessage(schema.AgentA, schema.AgentB, schema.TypeInject, "injected"))
+
+	records, _ := s.Query(Filter{Type: schema.TypeInject})
+	if len(records) != 1 {
+		t.Fatalf("expected 1 inject record, got %d", len(records))
+	}
+	if records[0].Message.Payload.Text != "injected" {
+		t.Errorf("unexpected record: %q", records[0].Message.Payload.Text)
+	}
+}
+
+func TestFilterFrom(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "from human"))
+	s.Append(EventResponse, schema.NewAgentMessage(schema.AgentA, schema.Human, "from agent-a"))
+
+	// Unlike Agent, From only matches the sending end
+	records, _ := s.Query(Filter{From: schema.AgentA})
+	if len(records) != 1 || records[0].Message.Payload.Text != "from agent-a" {
+		t.Errorf("expected only agent-a's message, got %+v", records)
+	}
+}
+
+func TestFilterTimeRange(t *testing.T) {
+	now := time.Now().UTC()
+	r := &Record{Time: now, Event: EventEnqueued, Message: schema.NewUserMessage(schema.AgentA, "x")}
+
+	if !(Filter{Since: now.Add(-time.Minute)}).Match(r) {
+		t.Error("expected record after Since to match")
+	}
+	if (Filter{Since: now.Add(time.Minute)}).Match(r) {
+		t.Error("expected record before Since to be excluded")
+	}
+	if (Filter{Until: now.Add(-time.Minute)}).Match(r) {
+		t.Error("expected record after Until to be excluded")
+	}
+	if !(Filter{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}).Match(r) {
+		t.Error("expected record inside range to match")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+
+	// A cursor on a missing file starts from nothing
+	c, err := s.Cursor()
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "first"))
+
+	later, _ := s.Cursor()
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "second"))
+
+	records, err := c.Next()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected both records, got %d, %v", len(records), err)
+	}
+	records, _ = later.Next()
+	if len(records) != 1 || records[0].Message.Payload.Text != "second" {
+		t.Errorf("expected only the record after the cursor, got %+v", records)
+	}
+	if records, _ := later.Next(); len(records) != 0 {
+		t.Errorf("expected nothing new, got %d records", len(records))
+	}
+
+	if records, _ := s.CursorAtStart().Next(); len(records) != 2 {
+		t.Errorf("expected the whole history from the start, got %d records", len(records))
+	}
+}
+
+func TestCursorHoldsBackPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	c, _ := s.Cursor()
+
+	line, _ := json.Marshal(&Record{Event: EventEnqueued, Message: schema.NewUserMessage(schema.AgentA, "split")})
+	f, _ := os.OpenFile(s.Path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	defer f.Close()
+
+	f.Write(line[:10])
+	if records, err := c.Next(); err != nil || len(records) != 0 {
+		t.Fatalf("expected a partial line held back, got %d, %v", len(records), err)
+	}
+	f.Write(append(line[10:], '\n'))
+	records, err := c.Next()
+	if err != nil || len(records) != 1 || records[0].Message.Payload.Text != "split" {
+		t.Errorf("expected the completed record, got %+v, %v", records, err)
+	}
+}
+
+func TestMalformedLinesSkipped(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	c := s.CursorAtStart()
+
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "before"))
+	// A line cut short by a crash, then a record appended after restart
+	f, _ := os.OpenFile(s.Path(), os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte(`{"time":"2025-01-01T00:00:00Z","event":"enq` + "\n"))
+	f.Close()
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "after"))
+
+	records, err := s.Query(Filter{})
+	var bad *MalformedError
+	if !errors.As(err, &bad) || bad.Skipped != 1 {
+		t.Errorf("expected one malformed line reported, got %v", err)
+	}
+	if len(records) != 2 || records[1].Message.Payload.Text != "after" {
+		t.Errorf("expected the records around the bad line, got %+v", records)
+	}
+
+	records, err = c.Next()
+	if !errors.As(err, &bad) || bad.Skipped != 1 {
+		t.Errorf("expected one malformed line reported by Next, got %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected the records around the bad line from Next, got %d", len(records))
+	}
+	if _, err := c.Next(); err != nil {
+		t.Errorf("expected the bad line reported only once, got %v", err)
+	}
+}
+
+func TestRecordWithoutMessageSkipped(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir)
+	c := s.CursorAtStart()
+
+	f, _ := os.OpenFile(s.Path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f.Write([]byte(`{"time":"2025-01-01T00:00:00Z","event":"processed"}` + "\n"))
+	f.Close()
+	s.Append(EventEnqueued, schema.NewUserMessage(schema.AgentA, "after"))
+
+	records, err := s.Query(Filter{})
+	var bad *MalformedError
+	if !errors.As(err, &bad) || bad.Skipped != 1 {
+		t.Errorf("expected the record without a message reported, got %v", err)
+	}
+	if len(records) != 1 || records[0].Message == nil {
+		t.Errorf("expected only the complete record, got %+v", records)
+	}
+
+	records, err = c.Next()
+	if !errors.As(err, &bad) || bad.Skipped != 1 {
+		t.Errorf("expected the record without a message reported by Next, got %v", err)
+	}
+	if len(records) != 1 || records[0].Message == nil {
+		t.Errorf("expected only the complete record from Next, got %+v", records)
+	}
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestLeaseAck(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	msg := schema.NewMessage("a", "b", schema.TypeMessage, "lease me")
+	q.Enqueue(msg)
+
+	lease, err := q.Lease(time.Minute)
+	if err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	if lease.Message.ID != msg.ID {
+		t.Errorf("leased wrong message: %q", lease.Message.ID)
+	}
+
+	// Leased message is hidden from the queue but reported in flight
+	n, _ := q.Len()
+	if n != 0 {
+		t.Errorf("expected leased message to leave the queue, got Len=%d", n)
+	}
+	inflight, _ := q.InFlight()
+	if len(inflight) != 1 || inflight[0].Message.ID != msg.ID {
+		t.Fatalf("expected 1 in-flight message, got %d", len(inflight))
+	}
+	if inflight[0].Deadline.Sub(lease.Deadline).Abs() > time.Microsecond {
+		t.Errorf("in-flight deadline %v does not match lease %v", inflight[0].Deadline, lease.Deadline)
+	}
+	if inflight[0].LeasedAt.Sub(lease.LeasedAt).Abs() > time.Second {
+		t.Errorf("in-flight lease time %v does not match lease %v", inflight[0].LeasedAt, lease.LeasedAt)
+	}
+
+	if err := q.Ack(lease); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	inflight, _ = q.InFlight()
+	if len(inflight) != 0 {
+		t.Errorf("expected nothing in flight after Ack, got %d", len(inflight))
+	}
+	if err := q.Ack(lease); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost on double Ack, got %v", err)
+	}
+}
+
+func TestLeaseEmpty(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	lease, err := q.Lease(time.Minute)
+	if err != nil {
+		t.Fatalf("Lease on empty queue should not error: %v", err)
+	}
+	if lease != nil {
+		t.Error("expected nil lease from empty queue")
+	}
+}
+
+func TestLeaseNack(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "retry me"))
+
+	lease, _ := q.Lease(time.Minute)
+	lease.Message.Attempts = 1
+	if err := q.Nack(lease); err != nil {
+		t.Fatalf("Nack failed: %v", err)
+	}
+
+	// Changes made while leased are written back
+	msg, _ := q.Peek()
+	if msg == nil || msg.Attempts != 1 {
+		t.Fatalf("expected nacked message back with Attempts=1, got %v", msg)
+	}
+	inflight, _ := q.InFlight()
+	if len(inflight) != 0 {
+		t.Errorf("expected nothing in flight after Nack, got %d", len(inflight))
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	msg := schema.NewMessage("a", "b", schema.TypeMessage, "crash")
+	q.Enqueue(msg)
+
+	// A consumer leases and then "crashes" without acking
+	stale, _ := q.Lease(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	lease, err := q.Lease(time.Minute)
+	if err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	if lease == nil || lease.Message.ID != msg.ID {
+		t.Fatal("expected expired lease to be redelivered")
+	}
+
+	// The stale consumer can no longer ack
+	if err := q.Ack(stale); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost for stale lease, got %v", err)
+	}
+	if err := q.Ack(lease); err != nil {
+		t.Errorf("Ack of fresh lease failed: %v", err)
+	}
+}
+
+func TestReclaimExpired(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "1"))
+	q.Lease(-time.Second)
+
+	if err := q.ReclaimExpired(); err != nil {
+		t.Fatalf("ReclaimExpired failed: %v", err)
+	}
+	n, _ := q.Len()
+	if n != 1 {
+		t.Errorf("expected reclaimed message back in queue, got Len=%d", n)
+	}
+}
@@ -1,25 +1,51 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
 	"github.com/binaryphile/cc-bridge/internal/schema"
 )
 
+var (
+	ErrNotFound  = errors.New("message not found")
+	ErrLeaseLost = errors.New("lease expired or already released")
+)
+
+// Lease is a message checked out of a queue. Until it is acked, nacked or its
+// deadline passes, the message sits in the queue's in-flight area where no
+// other consumer can see it.
+type Lease struct {
+	Message  *schema.Message
+	LeasedAt time.Time
+	Deadline time.Time
+	file     string
+}
+
 type Queue struct {
-	dir string
-	mu  sync.RWMutex
+	dir    string
+	mu     sync.RWMutex
+	notify chan struct{}
 }
 
 type Manager struct {
-	baseDir string
-	queues  map[string]*Queue
-	mu      sync.RWMutex
+	baseDir  string
+	readOnly bool // Set by OpenManager: directories are never created
+	queues   map[string]*Queue
+	dead     map[string]*Queue
+	archive  map[string]*Queue
+	watcher  *watcher
+	mu       sync.RWMutex
 }
 
 func NewManager(baseDir string) (*Manager, error) {
@@ -29,9 +55,24 @@ func NewManager(baseDir string) (*Manager, error) {
 	return &Manager{
 		baseDir: baseDir,
 		queues:  make(map[string]*Queue),
+		dead:    make(map[string]*Queue),
+		archive: make(map[string]*Queue),
 	}, nil
 }
 
+// OpenManager returns a manager for inspecting the queues under baseDir.
+// Unlike NewManager it creates no directories, and neither do the queues it
+// returns, so a queue that does not exist reads as empty.
+func OpenManager(baseDir string) *Manager {
+	return &Manager{
+		baseDir:  baseDir,
+		readOnly: true,
+		queues:   make(map[string]*Queue),
+		dead:     make(map[string]*Queue),
+		archive:  make(map[string]*Queue),
+	}
+}
+
 func (m *Manager) GetQueue(agent string) (*Queue, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -41,42 +82,160 @@ func (m *Manager) GetQueue(agent string) (*Queue, error) {
 	}
 
 	queueDir := filepath.Join(m.baseDir, agent)
-	if err := os.MkdirAll(queueDir, 0755); err != nil {
+	if err := m.mkdir(queueDir); err != nil {
 		return nil, fmt.Errorf("failed to create queue for %s: %w", agent, err)
 	}
 
-	q := &Queue{dir: queueDir}
+	q := newQueue(queueDir)
+	if m.watcher != nil {
+		if err := m.watcher.add(q); err != nil {
+			return nil, fmt.Errorf("failed to watch queue for %s: %w", agent, err)
+		}
+	}
 	m.queues[agent] = q
 	return q, nil
 }
 
-func (q *Queue) Enqueue(msg *schema.Message) error {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+// GetDeadLetterQueue returns the queue holding an agent's messages that ran
+// out of delivery attempts. It lives in a subdirectory of the agent's queue,
+// which the agent's own listing ignores.
+func (m *Manager) GetDeadLetterQueue(agent string) (*Queue, error) {
+	return m.subQueue(agent, "dead", m.dead)
+}
+
+// GetArchiveQueue returns the queue holding messages set aside when an agent
+// was removed. Like dead letters, they are kept under the agent's queue.
+func (m *Manager) GetArchiveQueue(agent string) (*Queue, error) {
+	return m.subQueue(agent, "archive", m.archive)
+}
+
+func (m *Manager) subQueue(agent, name string, cache map[string]*Queue) (*Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if q, ok := cache[agent]; ok {
+		return q, nil
+	}
+
+	dir := filepath.Join(m.baseDir, agent, name)
+	if err := m.mkdir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create %s queue for %s: %w", name, agent, err)
+	}
+
+	q := newQueue(dir)
+	cache[agent] = q
+	return q, nil
+}
+
+func (m *Manager) mkdir(dir string) error {
+	if m.readOnly {
+		return nil
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// ListAgents returns the names of all queue directories, including ones
+// created by other processes
+func (m *Manager) ListAgents() ([]string, error) {
+	entries, err := os.ReadDir(m.baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
 
+	agents := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			agents = append(agents, entry.Name())
+		}
+	}
+	return agents, nil
+}
+
+// Watch starts delivering wakeups for files written into any queue directory
+// by other processes. It stops when ctx is cancelled. On platforms without
+// filesystem notifications it returns an error and callers should rely on
+// polling alone.
+func (m *Manager) Watch(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watcher != nil {
+		return nil
+	}
+
+	w, err := newWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start queue watcher: %w", err)
+	}
+	for _, q := range m.queues {
+		if err := w.add(q); err != nil {
+			w.close()
+			return fmt.Errorf("failed to watch %s: %w", q.dir, err)
+		}
+	}
+	m.watcher = w
+
+	go w.run()
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		m.watcher = nil
+		m.mu.Unlock()
+		w.close()
+	}()
+	return nil
+}
+
+func newQueue(dir string) *Queue {
+	return &Queue{dir: dir, notify: make(chan struct{}, 1)}
+}
+
+// Notify returns a channel that receives a value whenever a message may have
+// arrived. Wakeups are coalesced, so a receiver should drain the queue rather
+// than assume one message per wakeup.
+func (q *Queue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue writes msg atomically, so consumers in other processes never see a
+// partially written file. It needs no lock: the rename is the commit point.
+func (q *Queue) Enqueue(msg *schema.Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	filename := fmt.Sprintf("%d_%s.json", msg.Timestamp.UnixNano(), msg.ID)
-	path := filepath.Join(q.dir, filename)
+	path := filepath.Join(q.dir, fileName(msg))
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write message: %w", err)
 	}
+	q.signal()
 	return nil
 }
 
 func (q *Queue) Dequeue() (*schema.Message, error) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	unlock, err := q.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	files, err := q.listFiles()
 	if err != nil {
 		return nil, err
 	}
-	if len(files) == 0 {
+	if len(files) == 0 || !isDue(files[0], time.Now()) {
 		return nil, nil
 	}
 
@@ -97,15 +256,228 @@ func (q *Queue) Dequeue() (*schema.Message, error) {
 	return msg, nil
 }
 
-func (q *Queue) Peek() (*schema.Message, error) {
+// Lease checks out the next due message for ttl. Leases whose deadline has
+// passed are returned to the queue first, so a consumer that crashed mid-turn
+// does not lose its message.
+func (q *Queue) Lease(ttl time.Duration) (*Lease, error) {
+	unlock, err := q.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	if err := q.reclaimExpired(now); err != nil {
+		return nil, err
+	}
+
+	files, err := q.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 || !isDue(files[0], now) {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(q.inflightDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create in-flight directory: %w", err)
+	}
+
+	deadline := now.Add(ttl)
+	leased := filepath.Join(q.inflightDir(), fmt.Sprintf("%d_%s", deadline.UnixNano(), files[0]))
+	if err := os.Rename(filepath.Join(q.dir, files[0]), leased); err != nil {
+		return nil, fmt.Errorf("failed to lease message: %w", err)
+	}
+	// The file's modification time records when it was leased
+	if err := os.Chtimes(leased, now, now); err != nil {
+		return nil, fmt.Errorf("failed to stamp lease: %w", err)
+	}
+
+	data, err := os.ReadFile(leased)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	msg, err := schema.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return &Lease{Message: msg, LeasedAt: now, Deadline: deadline, file: filepath.Base(leased)}, nil
+}
+
+// Ack removes a leased message for good
+func (q *Queue) Ack(l *Lease) error {
+	unlock, err := q.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(filepath.Join(q.inflightDir(), l.file)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to ack message: %w", err)
+	}
+	return nil
+}
+
+// Nack returns a leased message to the queue. Changes made to l.Message,
+// such as a retry count or NotBefore, are written back with it.
+func (q *Queue) Nack(l *Lease) error {
+	unlock, err := q.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	leased := filepath.Join(q.inflightDir(), l.file)
+	if _, err := os.Stat(leased); err != nil {
+		if os.IsNotExist(err) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to nack message: %w", err)
+	}
+
+	data, err := json.Marshal(l.Message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(filepath.Join(q.dir, fileName(l.Message)), data, 0644); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := os.Remove(leased); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	q.signal()
+	return nil
+}
+
+// InFlight returns the messages currently leased from this queue
+func (q *Queue) InFlight() ([]*Lease, error) {
+	unlock, err := q.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := listJSON(q.inflightDir())
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]*Lease, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(q.inflightDir(), file)
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // acked since listing
+			}
+			return nil, fmt.Errorf("failed to read in-flight message %s: %w", file, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read in-flight message %s: %w", file, err)
+		}
+		msg, err := schema.FromJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal in-flight message %s: %w", file, err)
+		}
+		leases = append(leases, &Lease{Message: msg, LeasedAt: info.ModTime(), Deadline: leaseDeadline(file), file: file})
+	}
+	return leases, nil
+}
+
+// ReclaimExpired returns messages whose lease deadline has passed to the queue
+func (q *Queue) ReclaimExpired() error {
+	unlock, err := q.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return q.reclaimExpired(time.Now())
+}
+
+func (q *Queue) reclaimExpired(now time.Time) error {
+	files, err := listJSON(q.inflightDir())
+	if err != nil {
+		return err
+	}
+
+	reclaimed := false
+	for _, file := range files {
+		if leaseDeadline(file).After(now) {
+			continue
+		}
+		_, original, _ := strings.Cut(file, "_")
+		err := os.Rename(filepath.Join(q.inflightDir(), file), filepath.Join(q.dir, original))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to reclaim %s: %w", file, err)
+		}
+		reclaimed = true
+	}
+	if reclaimed {
+		q.signal()
+	}
+	return nil
+}
+
+// lock serializes queue mutations within this process and, through an
+// advisory lock on the queue directory, with other cc-bridge processes.
+// The returned function releases both.
+func (q *Queue) lock() (func(), error) {
+	q.mu.Lock()
+	l, err := fsutil.LockFile(q.lockPath())
+	if err != nil {
+		q.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		l.Unlock()
+		q.mu.Unlock()
+	}, nil
+}
+
+// rlock is the shared counterpart of lock, used by operations that only read
+// and must not see files vanish between listing and reading them
+func (q *Queue) rlock() (func(), error) {
 	q.mu.RLock()
-	defer q.mu.RUnlock()
+	l, err := fsutil.RLockFile(q.lockPath())
+	if err != nil {
+		q.mu.RUnlock()
+		return nil, err
+	}
+	return func() {
+		l.Unlock()
+		q.mu.RUnlock()
+	}, nil
+}
+
+func (q *Queue) lockPath() string {
+	return filepath.Join(q.dir, ".lock")
+}
+
+func (q *Queue) inflightDir() string {
+	return filepath.Join(q.dir, "inflight")
+}
+
+func (q *Queue) Peek() (*schema.Message, error) {
+	unlock, err := q.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	files, err := q.listFiles()
 	if err != nil {
 		return nil, err
 	}
-	if len(files) == 0 {
+	if len(files) == 0 || !isDue(files[0], time.Now()) {
 		return nil, nil
 	}
 
@@ -118,8 +490,11 @@ func (q *Queue) Peek() (*schema.Message, error) {
 }
 
 func (q *Queue) Len() (int, error) {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	unlock, err := q.rlock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
 
 	files, err := q.listFiles()
 	if err != nil {
@@ -129,8 +504,11 @@ func (q *Queue) Len() (int, error) {
 }
 
 func (q *Queue) List() ([]*schema.Message, error) {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	unlock, err := q.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	files, err := q.listFiles()
 	if err != nil {
@@ -142,6 +520,10 @@ func (q *Queue) List() ([]*schema.Message, error) {
 		path := filepath.Join(q.dir, file)
 		data, err := os.ReadFile(path)
 		if err != nil {
+			// Without a lock file yet, a first lease can race the listing
+			if os.IsNotExist(err) {
+				continue
+			}
 			return nil, fmt.Errorf("failed to read message %s: %w", file, err)
 		}
 		msg, err := schema.FromJSON(data)
@@ -153,26 +535,135 @@ func (q *Queue) List() ([]*schema.Message, error) {
 	return messages, nil
 }
 
+func (q *Queue) Get(id string) (*schema.Message, error) {
+	unlock, err := q.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	file, err := q.findFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(q.dir, file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return schema.FromJSON(data)
+}
+
+func (q *Queue) Remove(id string) error {
+	unlock, err := q.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := q.findFile(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(q.dir, file)); err != nil {
+		return fmt.Errorf("failed to remove message file: %w", err)
+	}
+	return nil
+}
+
+// MoveTo moves every queued message, due or not, into dst and returns how
+// many were moved. Leased messages stay where they are.
+func (q *Queue) MoveTo(dst *Queue) (int, error) {
+	unlock, err := q.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	files, err := q.listFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, file := range files {
+		if err := os.Rename(filepath.Join(q.dir, file), filepath.Join(dst.dir, file)); err != nil {
+			return i, fmt.Errorf("failed to move %s: %w", file, err)
+		}
+	}
+	if len(files) > 0 {
+		dst.signal()
+	}
+	return len(files), nil
+}
+
+// Move readdresses the queued message id to to and transfers it to dst.
+// The message is written to dst before it leaves q, so a crash in between
+// can duplicate it but not lose it. Leased messages cannot be moved.
+func (q *Queue) Move(id string, dst *Queue, to string) (*schema.Message, error) {
+	unlock, err := q.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	file, err := q.findFile(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(q.dir, file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	msg, err := schema.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	msg.To = to
+	if err := dst.Enqueue(msg); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(filepath.Join(q.dir, file)); err != nil {
+		return nil, fmt.Errorf("failed to remove message file: %w", err)
+	}
+	return msg, nil
+}
+
 func (q *Queue) Clear() error {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	_, err := q.Purge()
+	return err
+}
+
+// Purge removes every queued message, due or not, and returns how many were
+// removed. Leased messages stay where they are.
+func (q *Queue) Purge() (int, error) {
+	unlock, err := q.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
 
 	files, err := q.listFiles()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	for _, file := range files {
+	for i, file := range files {
 		path := filepath.Join(q.dir, file)
 		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", file, err)
+			return i, fmt.Errorf("failed to remove %s: %w", file, err)
 		}
 	}
-	return nil
+	return len(files), nil
 }
 
 func (q *Queue) listFiles() ([]string, error) {
-	entries, err := os.ReadDir(q.dir)
+	return listJSON(q.dir)
+}
+
+func listJSON(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -189,3 +680,50 @@ func (q *Queue) listFiles() ([]string, error) {
 	sort.Strings(files)
 	return files, nil
 }
+
+func (q *Queue) findFile(id string) (string, error) {
+	files, err := q.listFiles()
+	if err != nil {
+		return "", err
+	}
+	suffix := "_" + id + ".json"
+	for _, file := range files {
+		if strings.HasSuffix(file, suffix) {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// fileName orders messages by when they become deliverable. A message
+// scheduled for retry sorts after everything already due.
+func fileName(msg *schema.Message) string {
+	due := msg.Timestamp
+	if msg.NotBefore != nil && msg.NotBefore.After(due) {
+		due = *msg.NotBefore
+	}
+	return fmt.Sprintf("%d_%s.json", due.UnixNano(), msg.ID)
+}
+
+// leaseDeadline extracts the deadline prefixed to an in-flight file name
+func leaseDeadline(file string) time.Time {
+	prefix, _, _ := strings.Cut(file, "_")
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// isDue reports whether the delivery time encoded in a file name has passed
+func isDue(file string, now time.Time) bool {
+	prefix, _, ok := strings.Cut(file, "_")
+	if !ok {
+		return true
+	}
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return true
+	}
+	return nanos <= now.UnixNano()
+}
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -20,6 +21,29 @@ func TestNewManager(t *testing.T) {
 	}
 }
 
+func TestOpenManager(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queues")
+	mgr := OpenManager(dir)
+
+	agents, err := mgr.ListAgents()
+	if err != nil || len(agents) != 0 {
+		t.Errorf("expected no queues, got %v, %v", agents, err)
+	}
+	q, _ := mgr.GetQueue("agent-a")
+	dlq, _ := mgr.GetDeadLetterQueue("agent-a")
+	for _, q := range []*Queue{q, dlq} {
+		if n, err := q.Len(); err != nil || n != 0 {
+			t.Errorf("expected a missing queue to read as empty, got %d, %v", n, err)
+		}
+		if leases, err := q.InFlight(); err != nil || len(leases) != 0 {
+			t.Errorf("expected nothing in flight, got %v, %v", leases, err)
+		}
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected nothing created, got %v", err)
+	}
+}
+
 func TestGetQueue(t *testing.T) {
 	dir := t.TempDir()
 	mgr, _ := NewManager(dir)
@@ -222,3 +246,209 @@ func TestClear(t *testing.T) {
 		t.Errorf("expected Len=0 after Clear, got %d", n)
 	}
 }
+
+func TestPurge(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "1"))
+	q.Lease(time.Minute)
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "2"))
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "3"))
+
+	n, err := q.Purge()
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected Purge to remove 2 messages, got %d", n)
+	}
+	if leases, _ := q.InFlight(); len(leases) != 1 {
+		t.Errorf("expected the leased message left alone, got %d in flight", len(leases))
+	}
+}
+
+func TestMove(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	src, _ := mgr.GetQueue("agent-a")
+	dst, _ := mgr.GetQueue("agent-b")
+
+	msg := schema.NewMessage("human", "agent-a", schema.TypeMessage, "move me")
+	src.Enqueue(msg)
+
+	moved, err := src.Move(msg.ID, dst, "agent-b")
+	if err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if moved.To != "agent-b" {
+		t.Errorf("expected moved message readdressed to agent-b, got %q", moved.To)
+	}
+	if n, _ := src.Len(); n != 0 {
+		t.Errorf("expected source queue empty, got Len=%d", n)
+	}
+	got, err := dst.Get(msg.ID)
+	if err != nil || got.To != "agent-b" || got.Payload.Text != "move me" {
+		t.Errorf("expected message in destination queue, got %+v, %v", got, err)
+	}
+
+	// A leased message is no longer in the queue to move
+	lease, _ := dst.Lease(time.Minute)
+	if _, err := dst.Move(lease.Message.ID, src, "agent-a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound moving a leased message, got %v", err)
+	}
+}
+
+func TestNotifyOnEnqueue(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	select {
+	case <-q.Notify():
+		t.Fatal("unexpected wakeup on empty queue")
+	default:
+	}
+
+	// Multiple enqueues coalesce into a single pending wakeup
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "1"))
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "2"))
+
+	select {
+	case <-q.Notify():
+	default:
+		t.Fatal("expected wakeup after Enqueue")
+	}
+	select {
+	case <-q.Notify():
+		t.Fatal("expected wakeups to be coalesced")
+	default:
+	}
+}
+
+func TestNotBeforeDelaysDelivery(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	delayed := schema.NewMessage("a", "b", schema.TypeMessage, "later")
+	due := time.Now().Add(time.Hour)
+	delayed.NotBefore = &due
+	q.Enqueue(delayed)
+
+	msg, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if msg != nil {
+		t.Fatal("expected delayed message to be held back")
+	}
+
+	// A message that is already due is delivered ahead of the delayed one
+	q.Enqueue(schema.NewMessage("a", "b", schema.TypeMessage, "now"))
+	msg, _ = q.Dequeue()
+	if msg == nil || msg.Payload.Text != "now" {
+		t.Fatalf("expected due message first, got %v", msg)
+	}
+
+	n, _ := q.Len()
+	if n != 1 {
+		t.Errorf("expected delayed message to still count toward Len, got %d", n)
+	}
+}
+
+func TestGetRemove(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("test")
+
+	keep := schema.NewMessage("a", "b", schema.TypeMessage, "keep")
+	drop := schema.NewMessage("a", "b", schema.TypeMessage, "drop")
+	q.Enqueue(keep)
+	q.Enqueue(drop)
+
+	got, err := q.Get(drop.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Payload.Text != "drop" {
+		t.Errorf("Get returned wrong message: %q", got.Payload.Text)
+	}
+
+	if err := q.Remove(drop.ID); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := q.Get(drop.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Remove, got %v", err)
+	}
+	if err := q.Remove(drop.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound removing twice, got %v", err)
+	}
+
+	n, _ := q.Len()
+	if n != 1 {
+		t.Errorf("expected Len=1, got %d", n)
+	}
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("agent-a")
+
+	dlq, err := mgr.GetDeadLetterQueue("agent-a")
+	if err != nil {
+		t.Fatalf("GetDeadLetterQueue failed: %v", err)
+	}
+	dlq.Enqueue(schema.NewMessage("a", "agent-a", schema.TypeMessage, "dead"))
+
+	// Dead letters are not visible to the agent's own queue
+	n, _ := q.Len()
+	if n != 0 {
+		t.Errorf("expected empty agent queue, got %d", n)
+	}
+	n, _ = dlq.Len()
+	if n != 1 {
+		t.Errorf("expected 1 dead letter, got %d", n)
+	}
+
+	agents, _ := mgr.ListAgents()
+	if len(agents) != 1 || agents[0] != "agent-a" {
+		t.Errorf("expected [agent-a], got %v", agents)
+	}
+}
+
+func TestMoveTo(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+	q, _ := mgr.GetQueue("agent-a")
+
+	q.Enqueue(schema.NewMessage("a", "agent-a", schema.TypeMessage, "1"))
+	delayed := schema.NewMessage("a", "agent-a", schema.TypeMessage, "2")
+	due := time.Now().Add(time.Hour)
+	delayed.NotBefore = &due
+	q.Enqueue(delayed)
+
+	archive, err := mgr.GetArchiveQueue("agent-a")
+	if err != nil {
+		t.Fatalf("GetArchiveQueue failed: %v", err)
+	}
+	n, err := q.MoveTo(archive)
+	if err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 moved, got %d", n)
+	}
+
+	if left, _ := q.Len(); left != 0 {
+		t.Errorf("expected source queue empty, got %d", left)
+	}
+	if got, _ := archive.Len(); got != 2 {
+		t.Errorf("expected 2 archived, got %d", got)
+	}
+	if _, err := archive.Get(delayed.ID); err != nil {
+		t.Errorf("expected delayed message to be archived: %v", err)
+	}
+}
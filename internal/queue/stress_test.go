@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+// TestHelperProcess is not a real test. The stress test re-executes the test
+// binary with QUEUE_HELPER set so producers and consumers run as separate
+// processes sharing one queue directory.
+func TestHelperProcess(t *testing.T) {
+	role := os.Getenv("QUEUE_HELPER")
+	if role == "" {
+		return
+	}
+
+	dir := os.Getenv("QUEUE_DIR")
+	mgr, err := NewManager(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	q, _ := mgr.GetQueue("agent")
+
+	switch role {
+	case "produce":
+		count, _ := strconv.Atoi(os.Getenv("QUEUE_COUNT"))
+		for i := 0; i < count; i++ {
+			msg := schema.NewMessage("producer", "agent", schema.TypeMessage, fmt.Sprintf("%s-%d", os.Getenv("QUEUE_NAME"), i))
+			if err := q.Enqueue(msg); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			fmt.Println(msg.ID)
+		}
+
+	case "consume-lease", "consume-dequeue":
+		done := filepath.Join(dir, "done")
+		for {
+			var msg *schema.Message
+			if role == "consume-lease" {
+				lease, err := q.Lease(time.Minute)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				}
+				if lease != nil {
+					msg = lease.Message
+					if err := q.Ack(lease); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(2)
+					}
+				}
+			} else {
+				msg, err = q.Dequeue()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				}
+			}
+
+			if msg != nil {
+				fmt.Println(msg.ID)
+				continue
+			}
+			// Only stop once producers have finished and the queue is drained
+			if _, err := os.Stat(done); err == nil {
+				if n, _ := q.Len(); n == 0 {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	os.Exit(0)
+}
+
+func startHelper(t *testing.T, dir, role string, env ...string) (*exec.Cmd, *bytes.Buffer) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), append(env, "QUEUE_HELPER="+role, "QUEUE_DIR="+dir)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start %s: %v", role, err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	return cmd, &stdout
+}
+
+func readIDs(buf *bytes.Buffer) []string {
+	var ids []string
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		ids = append(ids, scanner.Text())
+	}
+	return ids
+}
+
+// TestCrossProcess_NoLossNoDuplicates runs many producer and consumer
+// processes against one queue and checks every message is delivered once.
+func TestCrossProcess_NoLossNoDuplicates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping multi-process stress test in short mode")
+	}
+
+	dir := t.TempDir()
+	const producers, perProducer, consumers = 8, 50, 6
+
+	type proc struct {
+		cmd *exec.Cmd
+		out *bytes.Buffer
+	}
+	var producing, consuming []proc
+
+	for i := 0; i < consumers; i++ {
+		role := "consume-lease"
+		if i%2 == 1 {
+			role = "consume-dequeue"
+		}
+		cmd, out := startHelper(t, dir, role)
+		consuming = append(consuming, proc{cmd, out})
+	}
+	for i := 0; i < producers; i++ {
+		cmd, out := startHelper(t, dir, "produce",
+			fmt.Sprintf("QUEUE_COUNT=%d", perProducer), fmt.Sprintf("QUEUE_NAME=p%d", i))
+		producing = append(producing, proc{cmd, out})
+	}
+
+	sent := make(map[string]bool)
+	for _, p := range producing {
+		if err := p.cmd.Wait(); err != nil {
+			t.Fatalf("producer failed: %v", err)
+		}
+		for _, id := range readIDs(p.out) {
+			sent[id] = true
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "done"), nil, 0644)
+
+	received := make(map[string]int)
+	for _, c := range consuming {
+		if err := c.cmd.Wait(); err != nil {
+			t.Fatalf("consumer failed: %v", err)
+		}
+		for _, id := range readIDs(c.out) {
+			received[id]++
+		}
+	}
+
+	if len(sent) != producers*perProducer {
+		t.Fatalf("expected %d sent messages, got %d", producers*perProducer, len(sent))
+	}
+	for id := range sent {
+		switch received[id] {
+		case 1:
+		case 0:
+			t.Errorf("message %s was lost", id)
+		default:
+			t.Errorf("message %s delivered %d times", id, received[id])
+		}
+	}
+	for id := range received {
+		if !sent[id] {
+			t.Errorf("received unknown message %s", id)
+		}
+	}
+}
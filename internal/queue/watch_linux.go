@@ -0,0 +1,98 @@
+//go:build linux
+
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+
+// watcher turns inotify events on queue directories into queue wakeups
+type watcher struct {
+	file   *os.File
+	fd     int
+	queues map[int32]*Queue
+	mu     sync.Mutex
+}
+
+func newWatcher() (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		queues: make(map[int32]*Queue),
+	}, nil
+}
+
+func (w *watcher) add(q *Queue) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, q.dir, watchMask)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.queues[int32(wd)] = q
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *watcher) close() {
+	w.file.Close()
+}
+
+func (w *watcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			name := string(trimNull(buf[nameStart:nameEnd]))
+			offset = nameEnd
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.signalAll()
+				continue
+			}
+			if filepath.Ext(name) != ".json" {
+				continue
+			}
+
+			w.mu.Lock()
+			q := w.queues[event.Wd]
+			w.mu.Unlock()
+			if q != nil {
+				q.signal()
+			}
+		}
+	}
+}
+
+func (w *watcher) signalAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, q := range w.queues {
+		q.signal()
+	}
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+func TestWatch_OtherProcessEnqueue(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumer, _ := NewManager(dir)
+	q, _ := consumer.GetQueue("agent-a")
+	if err := consumer.Watch(ctx); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// A separate manager stands in for a CLI process writing to the queue
+	producer, _ := NewManager(dir)
+	pq, _ := producer.GetQueue("agent-a")
+	pq.Enqueue(schema.NewMessage("human", "agent-a", schema.TypeMessage, "hello"))
+
+	select {
+	case <-q.Notify():
+	case <-time.After(time.Second):
+		t.Fatal("expected wakeup from filesystem event")
+	}
+}
+
+func TestWatch_QueueCreatedAfterWatch(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumer, _ := NewManager(dir)
+	if err := consumer.Watch(ctx); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	q, _ := consumer.GetQueue("agent-b")
+
+	producer, _ := NewManager(dir)
+	pq, _ := producer.GetQueue("agent-b")
+	pq.Enqueue(schema.NewMessage("human", "agent-b", schema.TypeMessage, "late"))
+
+	select {
+	case <-q.Notify():
+	case <-time.After(time.Second):
+		t.Fatal("expected wakeup for queue created after Watch")
+	}
+}
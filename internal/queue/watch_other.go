@@ -0,0 +1,18 @@
+//go:build !linux
+
+package queue
+
+import "errors"
+
+// watcher is unavailable on this platform; brokers fall back to polling
+type watcher struct{}
+
+func newWatcher() (*watcher, error) {
+	return nil, errors.New("queue watching is not supported on this platform")
+}
+
+func (w *watcher) add(q *Queue) error { return nil }
+
+func (w *watcher) close() {}
+
+func (w *watcher) run() {}
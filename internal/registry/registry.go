@@ -0,0 +1,320 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
+	"github.com/binaryphile/cc-bridge/internal/schema"
+)
+
+const (
+	// StateActive agents receive messages and have a running worker
+	StateActive = "active"
+	// StateDraining agents keep processing what is already queued and are
+	// removed by the broker once their queue is empty
+	StateDraining = "draining"
+)
+
+var (
+	ErrNotFound = errors.New("agent not found")
+	ErrExists   = errors.New("agent already registered")
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type Agent struct {
+	ID      string    `json:"id"`
+	State   string    `json:"state"`
+	AddedAt time.Time `json:"added_at"`
+	Profile Profile   `json:"profile,omitzero"`
+}
+
+// Profile configures how an agent's claude process is invoked. Zero values
+// leave claude's own defaults in place. An agent with a Command runs that
+// program instead of claude, and only WorkDir, Workspace, TurnTimeout and
+// Budget also apply to it. An agent with an Endpoint talks to a Messages API
+// server instead. It requires a Model, sends both system prompts, and has no
+// working directory; of the rest only TurnTimeout and Budget apply.
+type Profile struct {
+	Model              string   `json:"model,omitempty"`
+	SystemPrompt       string   `json:"system_prompt,omitempty"`
+	AppendSystemPrompt string   `json:"append_system_prompt,omitempty"`
+	AllowedTools       []string `json:"allowed_tools,omitempty"`
+	DisallowedTools    []string `json:"disallowed_tools,omitempty"`
+	PermissionMode     string   `json:"permission_mode,omitempty"`
+	MaxTurns           int      `json:"max_turns,omitempty"` // Zero means one turn
+	WorkDir            string   `json:"work_dir,omitempty"`
+	Command            *Command `json:"command,omitempty"`
+	Endpoint           string   `json:"endpoint,omitempty"` // Base URL of a Messages API server
+
+	// Workspace runs the agent in its own directory under the data dir
+	// instead of WorkDir
+	Workspace *Workspace `json:"workspace,omitempty"`
+
+	// TurnTimeout overrides the broker's limit on how long one turn may run
+	TurnTimeout time.Duration `json:"turn_timeout,omitempty"`
+
+	// Budget overrides the broker's limit, in USD, on the agent's spend
+	Budget float64 `json:"budget,omitempty"`
+}
+
+// Workspace configures an agent's own working directory. With a Repo it is a
+// git worktree of that repository, otherwise an empty directory.
+type Workspace struct {
+	Repo string `json:"repo,omitempty"`
+}
+
+// Command configures an agent backed by a program other than claude. Argv
+// elements may contain the placeholders {{session_id}} and {{message}}.
+type Command struct {
+	Argv  []string `json:"argv"`
+	Stdin bool     `json:"stdin,omitempty"` // Also write the message to stdin
+
+	// Output is "text", where stdout is the reply, or "json", where Fields
+	// maps result fields to paths in the decoded stdout such as
+	// "$.choices[0].text"
+	Output string            `json:"output,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Command output formats
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// CommandFields are the result fields a JSON command output can map
+var CommandFields = []string{"response", "session_id", "cost", "is_error", "input_tokens", "output_tokens"}
+
+// Validate reports whether the command can be run
+func (c *Command) Validate() error {
+	if len(c.Argv) == 0 {
+		return errors.New("command must not be empty")
+	}
+	switch c.Output {
+	case "", OutputText:
+		if len(c.Fields) > 0 {
+			return errors.New("field mappings require json output")
+		}
+	case OutputJSON:
+	default:
+		return fmt.Errorf("unknown command output %q (want %s or %s)", c.Output, OutputText, OutputJSON)
+	}
+	for field := range c.Fields {
+		if !slices.Contains(CommandFields, field) {
+			return fmt.Errorf("unknown result field %q (want one of %s)", field, strings.Join(CommandFields, ", "))
+		}
+	}
+	return nil
+}
+
+// IsZero reports whether the profile sets nothing
+func (p Profile) IsZero() bool {
+	return p.Model == "" && p.SystemPrompt == "" && p.AppendSystemPrompt == "" &&
+		len(p.AllowedTools) == 0 && len(p.DisallowedTools) == 0 &&
+		p.PermissionMode == "" && p.MaxTurns == 0 && p.WorkDir == "" && p.Workspace == nil && p.Command == nil &&
+		p.Endpoint == "" && p.TurnTimeout == 0 && p.Budget == 0
+}
+
+// Registry is the set of agents the broker runs, persisted in agents.json.
+// It holds no state in memory: every call reads the file under a lock, so
+// changes made by the CLI are seen by a running broker.
+type Registry struct {
+	dir string
+}
+
+func NewRegistry(dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	return &Registry{dir: dir}, nil
+}
+
+// ValidateID reports whether id can be used as an agent name. IDs become
+// queue directory names, and the human inbox and broadcast names are reserved.
+func ValidateID(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("invalid agent ID %q: use letters, digits, '.', '_' or '-'", id)
+	}
+	if id == schema.Human || id == schema.Broadcast || id == schema.System {
+		return fmt.Errorf("invalid agent ID %q: name is reserved", id)
+	}
+	return nil
+}
+
+// Seed registers the given agents with profile if the registry has never
+// been written. Once agents.json exists it is left alone, even if it lists
+// no agents.
+func (r *Registry) Seed(profile Profile, ids ...string) error {
+	return r.update(func(agents map[string]*Agent, exists bool) error {
+		if exists {
+			return nil
+		}
+		now := time.Now().UTC()
+		for _, id := range ids {
+			agents[id] = &Agent{ID: id, State: StateActive, Profile: profile, AddedAt: now}
+		}
+		return nil
+	})
+}
+
+// Add registers a new active agent with the given profile. Adding an agent
+// that is draining makes it active again and replaces its profile.
+func (r *Registry) Add(id string, profile Profile) (*Agent, error) {
+	if err := ValidateID(id); err != nil {
+		return nil, err
+	}
+
+	var added *Agent
+	err := r.update(func(agents map[string]*Agent, _ bool) error {
+		if a, ok := agents[id]; ok {
+			if a.State != StateDraining {
+				return fmt.Errorf("%w: %s", ErrExists, id)
+			}
+			a.State = StateActive
+			a.Profile = profile
+			added = a
+			return nil
+		}
+		added = &Agent{ID: id, State: StateActive, AddedAt: time.Now().UTC(), Profile: profile}
+		agents[id] = added
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// SetState changes an agent's state
+func (r *Registry) SetState(id, state string) error {
+	return r.update(func(agents map[string]*Agent, _ bool) error {
+		a, ok := agents[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		a.State = state
+		return nil
+	})
+}
+
+// SetProfile replaces an agent's profile. A running broker applies it from
+// the agent's next turn.
+func (r *Registry) SetProfile(id string, profile Profile) error {
+	return r.update(func(agents map[string]*Agent, _ bool) error {
+		a, ok := agents[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		a.Profile = profile
+		return nil
+	})
+}
+
+// Remove unregisters an agent
+func (r *Registry) Remove(id string) error {
+	return r.update(func(agents map[string]*Agent, _ bool) error {
+		if _, ok := agents[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		delete(agents, id)
+		return nil
+	})
+}
+
+func (r *Registry) Get(id string) (*Agent, error) {
+	agents, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range agents {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// List returns all registered agents ordered by ID
+func (r *Registry) List() ([]*Agent, error) {
+	lock, err := fsutil.RLockFile(r.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock registry: %w", err)
+	}
+	defer lock.Unlock()
+
+	agents, _, err := r.readFile()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Agent, 0, len(agents))
+	for _, a := range agents {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (r *Registry) Path() string {
+	return filepath.Join(r.dir, "agents.json")
+}
+
+// update applies fn to the registry under an exclusive lock and writes the
+// result atomically. exists reports whether agents.json was already present.
+func (r *Registry) update(fn func(agents map[string]*Agent, exists bool) error) error {
+	lock, err := fsutil.LockFile(r.lockPath())
+	if err != nil {
+		return fmt.Errorf("failed to lock registry: %w", err)
+	}
+	defer lock.Unlock()
+
+	agents, exists, err := r.readFile()
+	if err != nil {
+		return err
+	}
+	if err := fn(agents, exists); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(agents, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal registry: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(r.Path(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	return nil
+}
+
+func (r *Registry) readFile() (map[string]*Agent, bool, error) {
+	agents := make(map[string]*Agent)
+
+	data, err := os.ReadFile(r.Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return agents, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &agents); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal registry: %w", err)
+	}
+	return agents, true, nil
+}
+
+func (r *Registry) lockPath() string {
+	return filepath.Join(r.dir, ".agents.lock")
+}
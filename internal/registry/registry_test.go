@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAddList(t *testing.T) {
+	dir := t.TempDir()
+	reg, _ := NewRegistry(dir)
+
+	if _, err := reg.Add("agent-b", Profile{}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	reg.Add("agent-a", Profile{})
+
+	agents, err := reg.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(agents) != 2 || agents[0].ID != "agent-a" || agents[1].ID != "agent-b" {
+		t.Fatalf("expected [agent-a agent-b], got %v", agents)
+	}
+	if agents[0].State != StateActive {
+		t.Errorf("expected new agent to be active, got %q", agents[0].State)
+	}
+
+	if _, err := reg.Add("agent-a", Profile{}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists adding twice, got %v", err)
+	}
+}
+
+func TestAddRejectsInvalidIDs(t *testing.T) {
+	dir := t.TempDir()
+	reg, _ := NewRegistry(dir)
+
+	for _, id := range []string{"", "human", "broadcast", "../escape", "a/b", ".hidden"} {
+		if _, err := reg.Add(id, Profile{}); err == nil {
+			t.Errorf("expected %q to be rejected", id)
+		}
+	}
+}
+
+func TestSharedAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	cli, _ := NewRegistry(dir)
+	broker, _ := NewRegistry(dir)
+
+	cli.Add("agent-c", Profile{})
+
+	agents, _ := broker.List()
+	if len(agents) != 1 || agents[0].ID != "agent-c" {
+		t.Fatalf("expected agent added elsewhere to be visible, got %v", agents)
+	}
+}
+
+func TestRemoveAndDrain(t *testing.T) {
+	dir := t.TempDir()
+	reg, _ := NewRegistry(dir)
+	reg.Add("agent-a", Profile{})
+
+	if err := reg.SetState("agent-a", StateDraining); err != nil {
+		t.Fatalf("SetState failed: %v", err)
+	}
+	a, _ := reg.Get("agent-a")
+	if a.State != StateDraining {
+		t.Errorf("expected draining, got %q", a.State)
+	}
+
+	// Adding a draining agent reactivates it
+	if _, err := reg.Add("agent-a", Profile{}); err != nil {
+		t.Fatalf("re-adding draining agent failed: %v", err)
+	}
+	a, _ = reg.Get("agent-a")
+	if a.State != StateActive {
+		t.Errorf("expected active after re-adding, got %q", a.State)
+	}
+
+	if err := reg.Remove("agent-a"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := reg.Get("agent-a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Remove, got %v", err)
+	}
+	if err := reg.Remove("agent-a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound removing twice, got %v", err)
+	}
+}
+
+func TestSeedOnlyOnce(t *testing.T) {
+	dir := t.TempDir()
+	reg, _ := NewRegistry(dir)
+
+	reg.Seed(Profile{Workspace: &Workspace{}}, "agent-a", "agent-b")
+	agents, _ := reg.List()
+	if len(agents) != 2 {
+		t.Fatalf("expected 2 seeded agents, got %d", len(agents))
+	}
+	if agents[0].Profile.Workspace == nil {
+		t.Errorf("expected seeded agents to get the profile, got %+v", agents[0].Profile)
+	}
+
+	// Removing every agent must not bring the defaults back
+	reg.Remove("agent-a")
+	reg.Remove("agent-b")
+	reg.Seed(Profile{}, "agent-a", "agent-b")
+	agents, _ = reg.List()
+	if len(agents) != 0 {
+		t.Errorf("expected seeding to be skipped once the registry exists, got %v", agents)
+	}
+}
+
+func TestProfilePersisted(t *testing.T) {
+	dir := t.TempDir()
+	reg, _ := NewRegistry(dir)
+
+	profile := Profile{
+		Model:        "opus",
+		SystemPrompt: "You review code.",
+		AllowedTools: []string{"Read", "Grep"},
+		MaxTurns:     5,
+	}
+	reg.Add("reviewer", profile)
+
+	a, _ := reg.Get("reviewer")
+	if a.Profile.Model != "opus" || a.Profile.MaxTurns != 5 || len(a.Profile.AllowedTools) != 2 {
+		t.Errorf("profile not persisted: %+v", a.Profile)
+	}
+
+	if err := reg.SetProfile("reviewer", Profile{Model: "haiku"}); err != nil {
+		t.Fatalf("SetProfile failed: %v", err)
+	}
+	a, _ = reg.Get("reviewer")
+	if a.Profile.Model != "haiku" || a.Profile.MaxTurns != 0 {
+		t.Errorf("expected profile replaced, got %+v", a.Profile)
+	}
+
+	if err := reg.SetProfile("missing", Profile{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	valid := []Command{
+		{Argv: []string{"bot", "{{message}}"}},
+		{Argv: []string{"bot"}, Output: OutputJSON, Fields: map[string]string{"response": "$.reply", "cost": "$.cost"}},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", c, err)
+		}
+	}
+
+	invalid := []Command{
+		{},
+		{Argv: []string{"bot"}, Output: "xml"},
+		{Argv: []string{"bot"}, Fields: map[string]string{"response": "$.reply"}},
+		{Argv: []string{"bot"}, Output: OutputJSON, Fields: map[string]string{"mood": "$.mood"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", c)
+		}
+	}
+}
@@ -12,6 +12,7 @@ const (
 	AgentB    = "agent-b"
 	Human     = "human"
 	Broadcast = "broadcast"
+	System    = "system" // Sender of notices from the broker itself
 )
 
 const (
@@ -29,6 +30,16 @@ type Message struct {
 	Type      string    `json:"type"`
 	Payload   Payload   `json:"payload"`
 	Context   *Context  `json:"context,omitempty"`
+	InReplyTo string    `json:"in_reply_to,omitempty"`
+	Hops      int       `json:"hops,omitempty"`
+
+	// CorrelationID ties the per-agent copies of a broadcast to the original
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Delivery state maintained by the broker across retries
+	Attempts    int        `json:"attempts,omitempty"`
+	MaxAttempts int        `json:"max_attempts,omitempty"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
 }
 
 type Payload struct {
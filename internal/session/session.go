@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
 )
 
 type Session struct {
@@ -15,11 +18,23 @@ type Session struct {
 	TurnNumber int       `json:"turn_number"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// ForkedFrom is the agent whose conversation this one branched from
+	ForkedFrom string `json:"forked_from,omitempty"`
+
+	// PendingFork is set until the first turn after a fork gives the
+	// session its own ID, so that turn must branch rather than resume
+	PendingFork bool `json:"pending_fork,omitempty"`
 }
 
+// Manager keeps sessions in memory and writes them through to
+// sessions.json on every change, so a crash never loses a session ID. Only
+// the sessions it changed are written, so it never overwrites what another
+// process saved for other agents since the last Load.
 type Manager struct {
 	dir      string
 	sessions map[string]*Session
+	dirty    map[string]bool // Changed sessions not yet written
 	mu       sync.RWMutex
 }
 
@@ -30,6 +45,7 @@ func NewManager(dir string) (*Manager, error) {
 	return &Manager{
 		dir:      dir,
 		sessions: make(map[string]*Session),
+		dirty:    make(map[string]bool),
 	}, nil
 }
 
@@ -37,6 +53,72 @@ func (m *Manager) CreateSession(agentID string) (*Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.createLocked(agentID)
+}
+
+// GetOrCreateSession returns the agent's session, creating a fresh one only
+// if none exists, so previously loaded session IDs are kept. A session
+// written by another process since the last Load, such as a fork, is adopted.
+func (m *Manager) GetOrCreateSession(agentID string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sess, ok := m.sessions[agentID]; ok {
+		return sess, nil
+	}
+
+	lock, err := fsutil.RLockFile(m.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock sessions: %w", err)
+	}
+	onDisk, err := m.readFile()
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if sess, ok := onDisk[agentID]; ok {
+		m.sessions[agentID] = sess
+		return sess, nil
+	}
+	return m.createLocked(agentID)
+}
+
+// Fork gives toAgent a copy of fromAgent's session. The copy is marked
+// PendingFork, so its next turn branches the conversation under a new session
+// ID and fromAgent's session carries on untouched.
+func (m *Manager) Fork(fromAgent, toAgent string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.sessions[fromAgent]
+	if !ok {
+		return nil, fmt.Errorf("session not found for agent: %s", fromAgent)
+	}
+	if src.SessionID == "" {
+		return nil, fmt.Errorf("%s has no conversation to fork", fromAgent)
+	}
+	if dst, ok := m.sessions[toAgent]; ok && dst.SessionID != "" {
+		return nil, fmt.Errorf("%s already has a session", toAgent)
+	}
+
+	now := time.Now().UTC()
+	sess := &Session{
+		AgentID:     toAgent,
+		SessionID:   src.SessionID,
+		TurnNumber:  src.TurnNumber,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ForkedFrom:  fromAgent,
+		PendingFork: true,
+	}
+	m.sessions[toAgent] = sess
+	if err := m.saveLocked(toAgent); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (m *Manager) createLocked(agentID string) (*Session, error) {
 	now := time.Now().UTC()
 	sess := &Session{
 		AgentID:    agentID,
@@ -46,6 +128,9 @@ func (m *Manager) CreateSession(agentID string) (*Session, error) {
 		UpdatedAt:  now,
 	}
 	m.sessions[agentID] = sess
+	if err := m.saveLocked(agentID); err != nil {
+		return nil, err
+	}
 	return sess, nil
 }
 
@@ -60,6 +145,8 @@ func (m *Manager) GetSession(agentID string) (*Session, error) {
 	return sess, nil
 }
 
+// SetSessionID records the agent's session ID. A new ID completes a
+// pending fork.
 func (m *Manager) SetSessionID(agentID, sessionID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -68,9 +155,12 @@ func (m *Manager) SetSessionID(agentID, sessionID string) error {
 	if !ok {
 		return fmt.Errorf("session not found for agent: %s", agentID)
 	}
+	if sessionID != sess.SessionID {
+		sess.PendingFork = false
+	}
 	sess.SessionID = sessionID
 	sess.UpdatedAt = time.Now().UTC()
-	return nil
+	return m.saveLocked(agentID)
 }
 
 func (m *Manager) IncrementTurn(agentID string) error {
@@ -83,9 +173,10 @@ func (m *Manager) IncrementTurn(agentID string) error {
 	}
 	sess.TurnNumber++
 	sess.UpdatedAt = time.Now().UTC()
-	return nil
+	return m.saveLocked(agentID)
 }
 
+// ListSessions returns all sessions ordered by agent ID
 func (m *Manager) ListSessions() []*Session {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -94,22 +185,58 @@ func (m *Manager) ListSessions() []*Session {
 	for _, s := range m.sessions {
 		sessions = append(sessions, s)
 	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].AgentID < sessions[j].AgentID
+	})
 	return sessions
 }
 
+// Save writes any changed sessions that a failed write left unsaved
 func (m *Manager) Save() error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.saveLocked()
+}
+
+// saveLocked marks the given agents' sessions changed and writes every
+// changed session to sessions.json atomically. All other entries are taken
+// from disk, so updates another process made to them are kept.
+func (m *Manager) saveLocked(changed ...string) error {
+	for _, id := range changed {
+		m.dirty[id] = true
+	}
+	if len(m.dirty) == 0 {
+		return nil
+	}
+
+	lock, err := fsutil.LockFile(m.lockPath())
+	if err != nil {
+		return fmt.Errorf("failed to lock sessions: %w", err)
+	}
+	defer lock.Unlock()
 
-	data, err := json.MarshalIndent(m.sessions, "", "  ")
+	onDisk, err := m.readFile()
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]*Session, len(onDisk)+len(m.dirty))
+	for id, sess := range onDisk {
+		merged[id] = sess
+	}
+	for id := range m.dirty {
+		merged[id] = m.sessions[id]
+	}
+
+	data, err := json.MarshalIndent(merged, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal sessions: %w", err)
 	}
 
-	path := filepath.Join(m.dir, "sessions.json")
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := fsutil.WriteFileAtomic(m.path(), data, 0644); err != nil {
 		return fmt.Errorf("failed to write sessions: %w", err)
 	}
+	clear(m.dirty)
 	return nil
 }
 
@@ -117,17 +244,105 @@ func (m *Manager) Load() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	path := filepath.Join(m.dir, "sessions.json")
+	lock, err := fsutil.RLockFile(m.lockPath())
+	if err != nil {
+		return fmt.Errorf("failed to lock sessions: %w", err)
+	}
+	defer lock.Unlock()
+
+	sessions, err := m.readFile()
+	if err != nil {
+		return err
+	}
+	for id, sess := range sessions {
+		if !m.dirty[id] {
+			m.sessions[id] = sess
+		}
+	}
+	return nil
+}
+
+func (m *Manager) readFile() (map[string]*Session, error) {
+	data, err := os.ReadFile(m.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // No state to load
+		}
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	var sessions map[string]*Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (m *Manager) path() string {
+	return filepath.Join(m.dir, "sessions.json")
+}
+
+func (m *Manager) lockPath() string {
+	return filepath.Join(m.dir, ".lock")
+}
+
+// TranscriptMessage is one message of a conversation the bridge keeps itself,
+// for executors whose backend has no server-side session
+type TranscriptMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Transcript returns the messages recorded for sessionID, oldest first
+func (m *Manager) Transcript(sessionID string) ([]TranscriptMessage, error) {
+	path, err := m.transcriptPath(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil // No state to load
+			return nil, nil
 		}
-		return fmt.Errorf("failed to read sessions: %w", err)
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+
+	var msgs []TranscriptMessage
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transcript: %w", err)
+	}
+	return msgs, nil
+}
+
+// AppendTranscript adds messages to the end of sessionID's transcript
+func (m *Manager) AppendTranscript(sessionID string, msgs ...TranscriptMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, err := m.Transcript(sessionID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(append(existing, msgs...), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %w", err)
 	}
 
-	if err := json.Unmarshal(data, &m.sessions); err != nil {
-		return fmt.Errorf("failed to unmarshal sessions: %w", err)
+	path, _ := m.transcriptPath(sessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create transcript directory: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write transcript: %w", err)
 	}
 	return nil
 }
+
+func (m *Manager) transcriptPath(sessionID string) (string, error) {
+	if sessionID == "" || sessionID != filepath.Base(sessionID) || sessionID[0] == '.' {
+		return "", fmt.Errorf("invalid session ID: %q", sessionID)
+	}
+	return filepath.Join(m.dir, "transcripts", sessionID+".json"), nil
+}
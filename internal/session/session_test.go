@@ -1,6 +1,7 @@
 package session
 
 import (
+	"os"
 	"testing"
 )
 
@@ -172,3 +173,205 @@ func TestListSessions(t *testing.T) {
 		t.Error("expected both agent-a and agent-b in list")
 	}
 }
+
+func TestWriteThrough(t *testing.T) {
+	dir := t.TempDir()
+	mgr1, _ := NewManager(dir)
+
+	// No explicit Save: every change is persisted as it happens
+	mgr1.CreateSession("agent-a")
+	mgr1.SetSessionID("agent-a", "live-session")
+	mgr1.IncrementTurn("agent-a")
+
+	mgr2, _ := NewManager(dir)
+	if err := mgr2.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	sess, err := mgr2.GetSession("agent-a")
+	if err != nil {
+		t.Fatalf("expected session persisted without Save: %v", err)
+	}
+	if sess.SessionID != "live-session" || sess.TurnNumber != 1 {
+		t.Errorf("expected live-session at turn 1, got %q at turn %d", sess.SessionID, sess.TurnNumber)
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	mgr, _ := NewManager(dir)
+
+	mgr.CreateSession("agent-a")
+	mgr.IncrementTurn("agent-a")
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != "sessions.json" && e.Name() != ".lock" {
+			t.Errorf("unexpected file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestSavePreservesOtherAgents(t *testing.T) {
+	dir := t.TempDir()
+
+	// Another process has written agent-b's session
+	other, _ := NewManager(dir)
+	other.CreateSession("agent-b")
+	other.SetSessionID("agent-b", "b-session")
+
+	mgr, _ := NewManager(dir)
+	mgr.CreateSession("agent-a")
+
+	check, _ := NewManager(dir)
+	check.Load()
+	if _, err := check.GetSession("agent-b"); err != nil {
+		t.Error("saving agent-a should not drop agent-b written elsewhere")
+	}
+	if _, err := check.GetSession("agent-a"); err != nil {
+		t.Error("expected agent-a to be saved")
+	}
+}
+
+func TestSaveKeepsUpdatesMadeElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	broker, _ := NewManager(dir)
+	broker.CreateSession("agent-a")
+	broker.CreateSession("agent-b")
+	broker.SetSessionID("agent-b", "b-session")
+
+	// The fork command loads every session, then the broker moves agent-a on
+	fork, _ := NewManager(dir)
+	fork.Load()
+	broker.SetSessionID("agent-a", "a-session")
+	broker.IncrementTurn("agent-a")
+
+	if _, err := fork.Fork("agent-b", "agent-c"); err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+
+	check, _ := NewManager(dir)
+	check.Load()
+	a, _ := check.GetSession("agent-a")
+	if a == nil || a.SessionID != "a-session" || a.TurnNumber != 1 {
+		t.Errorf("expected the broker's update to agent-a kept, got %+v", a)
+	}
+	if _, err := check.GetSession("agent-c"); err != nil {
+		t.Error("expected the fork to be saved")
+	}
+}
+
+func TestGetOrCreateSession(t *testing.T) {
+	dir := t.TempDir()
+	mgr1, _ := NewManager(dir)
+	mgr1.CreateSession("agent-a")
+	mgr1.SetSessionID("agent-a", "keep-me")
+
+	mgr2, _ := NewManager(dir)
+	mgr2.Load()
+
+	sess, err := mgr2.GetOrCreateSession("agent-a")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession failed: %v", err)
+	}
+	if sess.SessionID != "keep-me" {
+		t.Errorf("expected loaded session to be kept, got %q", sess.SessionID)
+	}
+
+	fresh, _ := mgr2.GetOrCreateSession("agent-b")
+	if fresh.SessionID != "" || fresh.TurnNumber != 0 {
+		t.Error("expected a fresh session for a new agent")
+	}
+}
+
+func TestGetOrCreateSession_AdoptsSessionWrittenElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	running, _ := NewManager(dir)
+	running.GetOrCreateSession("agent-a")
+
+	// Another process writes a session after the running manager loaded
+	cli, _ := NewManager(dir)
+	cli.CreateSession("agent-c")
+	cli.SetSessionID("agent-c", "from-cli")
+
+	sess, _ := running.GetOrCreateSession("agent-c")
+	if sess.SessionID != "from-cli" {
+		t.Errorf("expected session written by another process, got %q", sess.SessionID)
+	}
+}
+
+func TestFork(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := NewManager(dir)
+	m.CreateSession("agent-a")
+	if _, err := m.Fork("agent-a", "agent-a2"); err == nil {
+		t.Error("expected an error forking a session that has not started")
+	}
+
+	m.SetSessionID("agent-a", "original")
+	m.IncrementTurn("agent-a")
+	m.IncrementTurn("agent-a")
+
+	fork, err := m.Fork("agent-a", "agent-a2")
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	if fork.SessionID != "original" || fork.TurnNumber != 2 || fork.ForkedFrom != "agent-a" || !fork.PendingFork {
+		t.Errorf("unexpected fork: %+v", fork)
+	}
+	if _, err := m.Fork("agent-a", "agent-a2"); err == nil {
+		t.Error("expected an error forking onto an agent with a session")
+	}
+	if _, err := m.Fork("missing", "agent-x"); err == nil {
+		t.Error("expected an error forking an unknown agent")
+	}
+
+	// The fork is on disk for a broker to pick up
+	other, _ := NewManager(dir)
+	other.Load()
+	loaded, _ := other.GetSession("agent-a2")
+	if !loaded.PendingFork || loaded.SessionID != "original" {
+		t.Errorf("expected fork persisted, got %+v", loaded)
+	}
+
+	// The first turn's new session ID completes the fork
+	m.SetSessionID("agent-a2", "original")
+	if sess, _ := m.GetSession("agent-a2"); !sess.PendingFork {
+		t.Error("expected fork still pending while the ID is unchanged")
+	}
+	m.SetSessionID("agent-a2", "branched")
+	if sess, _ := m.GetSession("agent-a2"); sess.PendingFork || sess.ForkedFrom != "agent-a" {
+		t.Errorf("expected fork completed, got %+v", sess)
+	}
+	if sess, _ := m.GetSession("agent-a"); sess.SessionID != "original" || sess.PendingFork {
+		t.Errorf("expected original untouched, got %+v", sess)
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := NewManager(dir)
+
+	msgs, err := m.Transcript("http-1")
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("expected empty transcript, got %v, %v", msgs, err)
+	}
+
+	m.AppendTranscript("http-1", TranscriptMessage{Role: "user", Content: "hi"}, TranscriptMessage{Role: "assistant", Content: "hello"})
+	m.AppendTranscript("http-1", TranscriptMessage{Role: "user", Content: "again"})
+
+	// Visible to another manager on the same directory
+	other, _ := NewManager(dir)
+	msgs, err = other.Transcript("http-1")
+	if err != nil {
+		t.Fatalf("Transcript failed: %v", err)
+	}
+	if len(msgs) != 3 || msgs[1].Role != "assistant" || msgs[2].Content != "again" {
+		t.Errorf("unexpected transcript: %+v", msgs)
+	}
+
+	for _, id := range []string{"", "../escape", ".hidden"} {
+		if err := m.AppendTranscript(id, TranscriptMessage{Role: "user"}); err == nil {
+			t.Errorf("expected session ID %q to be rejected", id)
+		}
+	}
+}
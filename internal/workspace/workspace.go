@@ -0,0 +1,264 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/binaryphile/cc-bridge/internal/fsutil"
+)
+
+var (
+	ErrNotFound = errors.New("workspace not found")
+	ErrNoBase   = errors.New("workspace is a plain directory with nothing to diff against")
+)
+
+// BranchPrefix names the branches that worktree workspaces check out
+const BranchPrefix = "cc-bridge/"
+
+// Workspace is an agent's own working directory. A worktree workspace is a
+// git checkout, so what the agent changed can be shown as a diff against
+// Base. A plain workspace is only a directory.
+type Workspace struct {
+	Agent     string    `json:"agent"`
+	Path      string    `json:"path"`
+	Repo      string    `json:"repo,omitempty"`   // Empty for a plain directory
+	Branch    string    `json:"branch,omitempty"` // Branch of Repo the worktree checks out
+	Base      string    `json:"base,omitempty"`   // Commit the workspace started from, empty for a plain directory
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Manager creates workspaces under dir, one directory per agent, and records
+// them in .workspaces.json. Like the agent registry it holds no state in
+// memory.
+type Manager struct {
+	dir string
+}
+
+func NewManager(dir string) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+	return &Manager{dir: dir}, nil
+}
+
+// Path returns where the agent's workspace lives
+func (m *Manager) Path(agent string) string {
+	return filepath.Join(m.dir, agent)
+}
+
+// Ensure returns the agent's workspace, creating it if it does not exist.
+// With a repo the workspace is a worktree of it on the branch
+// cc-bridge/<agent>, which is created from the repo's HEAD if needed.
+// Without one it is an empty directory, and git is not needed.
+func (m *Manager) Ensure(agent, repo string) (*Workspace, error) {
+	if repo != "" {
+		top, err := git(repo, nil, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a git repository: %w", repo, err)
+		}
+		repo = strings.TrimSpace(string(top))
+	}
+
+	var ws *Workspace
+	err := m.update(func(all map[string]*Workspace) error {
+		if existing, ok := all[agent]; ok {
+			if existing.Repo != repo {
+				return fmt.Errorf("%s already has a workspace from %q at %s", agent, existing.Repo, existing.Path)
+			}
+			if _, err := os.Stat(existing.Path); err == nil {
+				ws = existing
+				return nil
+			}
+		}
+
+		created, err := m.create(agent, repo)
+		if err != nil {
+			return err
+		}
+		all[agent] = created
+		ws = created
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (m *Manager) create(agent, repo string) (*Workspace, error) {
+	ws := &Workspace{Agent: agent, Path: m.Path(agent), Repo: repo, CreatedAt: time.Now().UTC()}
+
+	if repo != "" {
+		ws.Branch = BranchPrefix + agent
+		// Forget worktrees whose directories were deleted, so the path and
+		// branch can be used again
+		if _, err := git(repo, nil, "worktree", "prune"); err != nil {
+			return nil, fmt.Errorf("failed to prune worktrees: %w", err)
+		}
+		args := []string{"worktree", "add", "-b", ws.Branch, ws.Path, "HEAD"}
+		if _, err := git(repo, nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+ws.Branch); err == nil {
+			args = []string{"worktree", "add", ws.Path, ws.Branch}
+		}
+		if _, err := git(repo, nil, args...); err != nil {
+			return nil, fmt.Errorf("failed to add worktree: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(ws.Path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create workspace: %w", err)
+		}
+		return ws, nil
+	}
+
+	head, err := git(ws.Path, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace HEAD: %w", err)
+	}
+	ws.Base = strings.TrimSpace(string(head))
+	return ws, nil
+}
+
+// Get returns the agent's workspace
+func (m *Manager) Get(agent string) (*Workspace, error) {
+	all, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	ws, ok := all[agent]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, agent)
+	}
+	return ws, nil
+}
+
+// List returns all workspaces ordered by agent
+func (m *Manager) List() ([]*Workspace, error) {
+	all, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Workspace, 0, len(all))
+	for _, ws := range all {
+		list = append(list, ws)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Agent < list[j].Agent })
+	return list, nil
+}
+
+// Diff returns everything the agent changed since its workspace was created:
+// commits, uncommitted edits and new files not ignored by git. The
+// workspace's own index is left alone. A plain workspace has no starting
+// commit, so its diff fails with ErrNoBase.
+func (m *Manager) Diff(agent string) ([]byte, error) {
+	ws, err := m.Get(agent)
+	if err != nil {
+		return nil, err
+	}
+	if ws.Base == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoBase, agent)
+	}
+
+	tmp, err := os.MkdirTemp("", "cc-bridge-diff-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary index: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+
+	if _, err := git(ws.Path, env, "read-tree", "HEAD"); err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	if _, err := git(ws.Path, env, "add", "--all"); err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	diff, err := git(ws.Path, env, "diff", "--cached", ws.Base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff workspace: %w", err)
+	}
+	return diff, nil
+}
+
+func (m *Manager) read() (map[string]*Workspace, error) {
+	lock, err := fsutil.RLockFile(m.lockPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock workspaces: %w", err)
+	}
+	defer lock.Unlock()
+
+	return m.readFile()
+}
+
+func (m *Manager) update(fn func(all map[string]*Workspace) error) error {
+	lock, err := fsutil.LockFile(m.lockPath())
+	if err != nil {
+		return fmt.Errorf("failed to lock workspaces: %w", err)
+	}
+	defer lock.Unlock()
+
+	all, err := m.readFile()
+	if err != nil {
+		return err
+	}
+	if err := fn(all); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal workspaces: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(m.path(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write workspaces: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) readFile() (map[string]*Workspace, error) {
+	all := make(map[string]*Workspace)
+
+	data, err := os.ReadFile(m.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return all, nil
+		}
+		return nil, fmt.Errorf("failed to read workspaces: %w", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workspaces: %w", err)
+	}
+	return all, nil
+}
+
+// The metadata files start with a dot so they cannot clash with agent IDs
+func (m *Manager) path() string {
+	return filepath.Join(m.dir, ".workspaces.json")
+}
+
+func (m *Manager) lockPath() string {
+	return filepath.Join(m.dir, ".lock")
+}
+
+// git runs git in dir with extra environment variables, including its
+// stderr in the error
+func git(dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%w: %s", err, msg)
+		}
+		return out, err
+	}
+	return out, nil
+}
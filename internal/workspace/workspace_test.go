@@ -0,0 +1,139 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRepo creates a git repository with one committed file
+func newRepo(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "README"), []byte("hello\n"), 0644)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "README"},
+		{"-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	return repo
+}
+
+func TestEnsureWorktree(t *testing.T) {
+	repo := newRepo(t)
+	m, _ := NewManager(t.TempDir())
+
+	ws, err := m.Ensure("agent-a", repo)
+	if err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if ws.Path != m.Path("agent-a") || ws.Branch != "cc-bridge/agent-a" || ws.Base == "" {
+		t.Errorf("unexpected workspace: %+v", ws)
+	}
+	if _, err := os.Stat(filepath.Join(ws.Path, "README")); err != nil {
+		t.Errorf("expected the repo checked out: %v", err)
+	}
+
+	// Each agent gets its own checkout
+	other, _ := m.Ensure("agent-b", repo)
+	os.WriteFile(filepath.Join(ws.Path, "README"), []byte("changed by a\n"), 0644)
+	if data, _ := os.ReadFile(filepath.Join(other.Path, "README")); string(data) != "hello\n" {
+		t.Errorf("expected agent-b unaffected by agent-a's edit, got %q", data)
+	}
+
+	again, err := m.Ensure("agent-a", repo)
+	if err != nil || again.Base != ws.Base {
+		t.Errorf("expected the existing workspace, got %+v, %v", again, err)
+	}
+	if _, err := m.Ensure("agent-a", ""); err == nil {
+		t.Error("expected an error switching an existing workspace to a plain directory")
+	}
+	if _, err := m.Ensure("agent-c", t.TempDir()); err == nil {
+		t.Error("expected an error for a directory that is not a repository")
+	}
+}
+
+func TestEnsureRecreatesDeletedWorktree(t *testing.T) {
+	repo := newRepo(t)
+	m, _ := NewManager(t.TempDir())
+
+	ws, _ := m.Ensure("agent-a", repo)
+	os.RemoveAll(ws.Path)
+
+	again, err := m.Ensure("agent-a", repo)
+	if err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(again.Path, "README")); err != nil {
+		t.Errorf("expected the worktree recreated on the existing branch: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	repo := newRepo(t)
+	m, _ := NewManager(t.TempDir())
+	ws, _ := m.Ensure("agent-a", repo)
+
+	diff, err := m.Diff("agent-a")
+	if err != nil || len(diff) != 0 {
+		t.Fatalf("expected an empty diff, got %q, %v", diff, err)
+	}
+
+	// An edit, a new file and a commit all show up
+	os.WriteFile(filepath.Join(ws.Path, "README"), []byte("edited\n"), 0644)
+	os.WriteFile(filepath.Join(ws.Path, "new.txt"), []byte("new file\n"), 0644)
+	os.WriteFile(filepath.Join(ws.Path, "committed.txt"), []byte("committed\n"), 0644)
+	exec.Command("git", "-C", ws.Path, "add", "committed.txt").Run()
+	exec.Command("git", "-C", ws.Path, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "-m", "work").Run()
+
+	diff, err = m.Diff("agent-a")
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	for _, want := range []string{"+edited", "+new file", "+committed"} {
+		if !strings.Contains(string(diff), want) {
+			t.Errorf("expected %q in diff:\n%s", want, diff)
+		}
+	}
+
+	// The agent's own index is untouched
+	status, _ := exec.Command("git", "-C", ws.Path, "status", "--porcelain").Output()
+	if !strings.Contains(string(status), "?? new.txt") {
+		t.Errorf("expected new.txt still untracked, got %q", status)
+	}
+
+	if _, err := m.Diff("agent-x"); err == nil {
+		t.Error("expected an error for an agent without a workspace")
+	}
+}
+
+func TestEnsurePlainDirectory(t *testing.T) {
+	m, _ := NewManager(t.TempDir())
+
+	ws, err := m.Ensure("agent-a", "")
+	if err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if ws.Repo != "" || ws.Branch != "" || ws.Base != "" {
+		t.Errorf("unexpected workspace: %+v", ws)
+	}
+	if _, err := os.Stat(filepath.Join(ws.Path, ".git")); !os.IsNotExist(err) {
+		t.Errorf("expected a plain directory without git, got %v", err)
+	}
+
+	if _, err := m.Diff("agent-a"); !errors.Is(err, ErrNoBase) {
+		t.Errorf("expected ErrNoBase for a plain workspace, got %v", err)
+	}
+
+	list, _ := m.List()
+	if len(list) != 1 || list[0].Agent != "agent-a" {
+		t.Errorf("unexpected list: %+v", list)
+	}
+}